@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"log"
+	"sort"
+)
+
+// AVRO_EVENT_SCHEMA describes events in Avro container files.  Typed
+// headers are written as their strings in headers, and ingest_time is in
+// microseconds since the epoch.
+const AVRO_EVENT_SCHEMA = `{"type":"record","name":"Event","namespace":"collectord","fields":[` +
+	`{"name":"version","type":"int"},` +
+	`{"name":"id","type":"string"},` +
+	`{"name":"ingest_time","type":{"type":"long","logicalType":"timestamp-micros"}},` +
+	`{"name":"headers","type":{"type":"map","values":"string"}},` +
+	`{"name":"body","type":"bytes"}]}`
+
+// avroSerializer writes Avro object container files, uncompressed, with
+// each event in a block of its own so nothing has to be buffered between
+// calls to Serialize.
+type avroSerializer struct {
+	sync [16]byte
+}
+
+func newAvroSerializer(config ComponentSettings) Serializer {
+	a := &avroSerializer{}
+	if _, err := rand.Read(a.sync[:]); err != nil {
+		log.Fatalf("avro: can't make sync marker: %s", err)
+	}
+	return a
+}
+
+func (a *avroSerializer) Begin() []byte {
+	header := []byte("Obj\x01")
+	header = appendAvroLong(header, 2)
+	header = appendAvroString(header, "avro.schema")
+	header = appendAvroString(header, AVRO_EVENT_SCHEMA)
+	header = appendAvroString(header, "avro.codec")
+	header = appendAvroString(header, "null")
+	header = appendAvroLong(header, 0)
+	return append(header, a.sync[:]...)
+}
+
+func (a *avroSerializer) Serialize(event Event) ([]byte, error) {
+	record := appendAvroLong(nil, int64(event.Version))
+	record = appendAvroString(record, event.ID)
+	micros := int64(0)
+	if !event.IngestTime.IsZero() {
+		micros = event.IngestTime.UnixNano() / 1000
+	}
+	record = appendAvroLong(record, micros)
+
+	// sorted so the same event always serializes the same way
+	names := make([]string, 0, len(event.Headers))
+	for name := range event.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		record = appendAvroLong(record, int64(len(names)))
+		for _, name := range names {
+			record = appendAvroString(record, name)
+			record = appendAvroString(record, event.Headers[name])
+		}
+	}
+	record = appendAvroLong(record, 0)
+	record = appendAvroBytes(record, event.Body)
+
+	block := appendAvroLong(make([]byte, 0, len(record)+32), 1)
+	block = appendAvroLong(block, int64(len(record)))
+	block = append(block, record...)
+	return append(block, a.sync[:]...), nil
+}
+
+// Avro ints and longs are zigzag varints, and strings and bytes are
+// prefixed with their length.
+
+func appendAvroLong(b []byte, v int64) []byte {
+	return binary.AppendVarint(b, v)
+}
+
+func appendAvroBytes(b []byte, v []byte) []byte {
+	b = appendAvroLong(b, int64(len(v)))
+	return append(b, v...)
+}
+
+func appendAvroString(b []byte, v string) []byte {
+	b = appendAvroLong(b, int64(len(v)))
+	return append(b, v...)
+}
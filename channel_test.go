@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func makeDummyEvents(count int) []Event {
@@ -109,6 +111,37 @@ func ChannelConfirmGetTest(c Channel, t *testing.T) {
 	}
 }
 
+func ChannelWaitForEventsTest(c Channel, t *testing.T) {
+	if c.WaitForEvents(context.Background(), 10*time.Millisecond) {
+		t.Fatalf("Empty channel reported events available")
+	}
+
+	events := makeDummyEvents(1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.AddEvent(events[0])
+	}()
+
+	start := time.Now()
+	if !c.WaitForEvents(context.Background(), 5*time.Second) {
+		t.Fatalf("Timed out waiting for event")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Wakeup took too long: %s", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	count, _, err := c.GetAll()
+	if err != nil {
+		t.Fatalf("Failed to get events: %s", err)
+	}
+	c.ConfirmGet(count)
+	if c.WaitForEvents(ctx, 0) {
+		t.Errorf("Cancelled wait on empty channel reported events available")
+	}
+}
+
 func ChannelStartTest(c Channel, t *testing.T) {
 	err := c.Start()
 	if err != nil {
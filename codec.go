@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func init() {
+	RegisterEventCodec("json", jsonCodec{})
+	RegisterEventCodec("msgpack", msgpackCodec{})
+	RegisterEventCodec("protobuf", protobufCodec{})
+	RegisterEventCodec("gob", gobCodec{})
+}
+
+// EventCodec serializes single events for storage, such as the rows of a
+// sqlite channel.  Codecs must be able to decode anything they encoded with
+// an older version of the Event struct.
+type EventCodec interface {
+	Encode(Event) ([]byte, error)
+	Decode([]byte, *Event) error
+}
+
+// Global codec registry
+
+var registeredEventCodecs map[string]EventCodec = make(map[string]EventCodec)
+
+func RegisterEventCodec(name string, codec EventCodec) {
+	registeredEventCodecs[name] = codec
+}
+
+func NewEventCodec(name string) EventCodec {
+	codec, ok := registeredEventCodecs[name]
+	if !ok {
+		log.Fatalf("No event codec registered for name [%s]", name)
+	}
+	return codec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (jsonCodec) Decode(data []byte, e *Event) error {
+	return json.Unmarshal(data, e)
+}
+
+// msgpackCodec stores bodies as raw bytes rather than base64, and leaves out
+// empty fields.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(e Event) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetOmitEmpty(true)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte, e *Event) error {
+	return msgpack.Unmarshal(data, e)
+}
+
+// gobCodec writes a self describing gob stream per event, so every row
+// carries the type definitions.  It's mostly useful for comparison.
+type gobCodec struct{}
+
+func (gobCodec) Encode(e Event) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, e *Event) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(e)
+}
+
+var errBadProtobuf = errors.New("malformed protobuf event")
+
+// protobufCodec encodes events by hand as this protobuf message, so no
+// generated code is needed:
+//
+//	message Event {
+//	  int64 version = 1;
+//	  string id = 2;
+//	  int64 ingest_time = 3;             // unix nanos
+//	  map<string, string> headers = 4;
+//	  map<string, Value> typed_headers = 5;
+//	  bytes body = 6;
+//	}
+//
+//	message Value {
+//	  int64 type = 1;
+//	  string str = 2;
+//	  sint64 int = 3;
+//	  double float = 4;
+//	  bool bool = 5;
+//	  bytes bytes = 6;
+//	  int64 time = 7;                    // unix nanos
+//	}
+type protobufCodec struct{}
+
+func (protobufCodec) Encode(e Event) ([]byte, error) {
+	var b []byte
+	if e.Version != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.Version))
+	}
+	if e.ID != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, e.ID)
+	}
+	if !e.IngestTime.IsZero() {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.IngestTime.UnixNano()))
+	}
+	for name, value := range e.Headers {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, name)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, value)
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	for name, value := range e.TypedHeaders {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, name)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, appendProtobufValue(nil, value))
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if len(e.Body) > 0 {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, e.Body)
+	}
+	return b, nil
+}
+
+func appendProtobufValue(b []byte, v Value) []byte {
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(v.Type))
+	switch v.Type {
+	case StringType:
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, v.Str)
+	case IntType:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(v.Int))
+	case FloatType:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v.Float))
+	case BoolType:
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v.Bool))
+	case BytesType:
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, v.Bytes)
+	case TimeType:
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.Time.UnixNano()))
+	}
+	return b
+}
+
+func (protobufCodec) Decode(data []byte, e *Event) error {
+	*e = Event{}
+	return walkProtobuf(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.Version = int(v)
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			e.ID = v
+			return n, nil
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.IngestTime = time.Unix(0, int64(v)).UTC()
+			return n, nil
+		case num == 4 && typ == protowire.BytesType:
+			entry, n := protowire.ConsumeBytes(b)
+			name, value, err := decodeProtobufEntry(entry)
+			if err != nil {
+				return 0, err
+			}
+			if e.Headers == nil {
+				e.Headers = make(map[string]string)
+			}
+			v, m := protowire.ConsumeString(value)
+			if m < 0 {
+				return 0, errBadProtobuf
+			}
+			e.Headers[name] = v
+			return n, nil
+		case num == 5 && typ == protowire.BytesType:
+			entry, n := protowire.ConsumeBytes(b)
+			name, value, err := decodeProtobufEntry(entry)
+			if err != nil {
+				return 0, err
+			}
+			raw, m := protowire.ConsumeBytes(value)
+			if m < 0 {
+				return 0, errBadProtobuf
+			}
+			v, err := decodeProtobufValue(raw)
+			if err != nil {
+				return 0, err
+			}
+			if e.TypedHeaders == nil {
+				e.TypedHeaders = make(map[string]Value)
+			}
+			e.TypedHeaders[name] = v
+			return n, nil
+		case num == 6 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			e.Body = append([]byte(nil), v...)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+// decodeProtobufEntry splits a map entry into its key and the still encoded
+// value field (tag excluded), which is empty for a default value.
+func decodeProtobufEntry(entry []byte) (string, []byte, error) {
+	var key string
+	value := protowire.AppendBytes(nil, nil)
+	err := walkProtobuf(entry, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		if n >= 0 && typ == protowire.BytesType {
+			switch num {
+			case 1:
+				key, _ = protowire.ConsumeString(b)
+			case 2:
+				value = b[:n]
+			}
+		}
+		return n, nil
+	})
+	return key, value, err
+}
+
+func decodeProtobufValue(data []byte) (Value, error) {
+	var v Value
+	err := walkProtobuf(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		var n int
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			var t uint64
+			t, n = protowire.ConsumeVarint(b)
+			v.Type = ValueType(t)
+		case num == 2 && typ == protowire.BytesType:
+			v.Str, n = protowire.ConsumeString(b)
+		case num == 3 && typ == protowire.VarintType:
+			var i uint64
+			i, n = protowire.ConsumeVarint(b)
+			v.Int = protowire.DecodeZigZag(i)
+		case num == 4 && typ == protowire.Fixed64Type:
+			var f uint64
+			f, n = protowire.ConsumeFixed64(b)
+			v.Float = math.Float64frombits(f)
+		case num == 5 && typ == protowire.VarintType:
+			var x uint64
+			x, n = protowire.ConsumeVarint(b)
+			v.Bool = protowire.DecodeBool(x)
+		case num == 6 && typ == protowire.BytesType:
+			var raw []byte
+			raw, n = protowire.ConsumeBytes(b)
+			v.Bytes = append([]byte(nil), raw...)
+		case num == 7 && typ == protowire.VarintType:
+			var t uint64
+			t, n = protowire.ConsumeVarint(b)
+			v.Time = time.Unix(0, int64(t)).UTC()
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		return n, nil
+	})
+	return v, err
+}
+
+// walkProtobuf calls field with the value bytes of each field in data.
+// field returns how many bytes it consumed, negative on malformed input.
+func walkProtobuf(data []byte, field func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errBadProtobuf
+		}
+		data = data[n:]
+
+		n, err := field(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errBadProtobuf
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var codecNames = []string{"json", "msgpack", "protobuf", "gob"}
+
+func codecTestEvent() Event {
+	e := NewEvent()
+	e.Body = []byte("binary \x00\xff\xfe body\n")
+	e.Headers["Host"] = "web1"
+	e.Headers["Empty"] = ""
+	SetEventTime(&e, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), TIMESTAMP_UNIX_MS)
+	e.SetHeader("Severity", IntValue(-3))
+	e.SetHeader("Ratio", FloatValue(0.25))
+	e.SetHeader("Ok", BoolValue(true))
+	e.SetHeader("Raw", BytesValue([]byte{0, 1, 2}))
+	e.SetHeader("When", TimeValue(time.Date(2026, 10, 19, 12, 0, 0, 123, time.UTC)))
+	return e
+}
+
+func TestEventCodecRoundTrip(t *testing.T) {
+	expected := codecTestEvent()
+	for _, name := range codecNames {
+		codec := NewEventCodec(name)
+		encoded, err := codec.Encode(expected)
+		if err != nil {
+			t.Fatalf("%s: encode: %s", name, err)
+		}
+
+		var e Event
+		if err := codec.Decode(encoded, &e); err != nil {
+			t.Fatalf("%s: decode: %s", name, err)
+		}
+		upgradeEvent(&e)
+
+		if e.ID != expected.ID || e.Version != expected.Version || !e.IngestTime.Equal(expected.IngestTime) {
+			t.Errorf("%s: expected id/version/ingest time %s/%d/%s, got %s/%d/%s", name,
+				expected.ID, expected.Version, expected.IngestTime, e.ID, e.Version, e.IngestTime)
+		}
+		if !bytes.Equal(e.Body, expected.Body) {
+			t.Errorf("%s: expected body %q, got %q", name, expected.Body, e.Body)
+		}
+		if !reflect.DeepEqual(e.Headers, expected.Headers) {
+			t.Errorf("%s: expected headers %v, got %v", name, expected.Headers, e.Headers)
+		}
+		for header, value := range expected.TypedHeaders {
+			got, _ := e.Header(header)
+			if got.Type != value.Type || got.String() != value.String() {
+				t.Errorf("%s: expected %s header %+v, got %+v", name, header, value, got)
+			}
+		}
+	}
+}
+
+func TestProtobufCodecMalformed(t *testing.T) {
+	var e Event
+	if err := NewEventCodec("protobuf").Decode([]byte{0x32, 0x10, 'a'}, &e); err == nil {
+		t.Error("expected error decoding truncated body")
+	}
+}
+
+func initSqliteChannelCodecTest(codec string) (ComponentSettings, Channel) {
+	return initSqliteChannelSettingsTest(ComponentSettings{"codec": codec})
+}
+
+func TestSqliteChannelCodecs(t *testing.T) {
+	for _, name := range codecNames {
+		c, channel := initSqliteChannelCodecTest(name)
+
+		ChannelAddEventsTest(channel, t)
+		ChannelConfirmGetTest(channel, t)
+		cleanupSqliteChannelTest(c, channel)
+	}
+}
+
+func TestSqliteChannelSwitchCodec(t *testing.T) {
+	c, channel := initSqliteChannelTest()
+	defer cleanupSqliteChannelTest(c, channel)
+
+	old := NewEvent()
+	old.Body = []byte("written as json")
+	if err := channel.AddEvent(old); err != nil {
+		t.Fatalf("add event: %s", err)
+	}
+	channel.(*SqliteChannel).db.Close()
+
+	c["codec"] = "msgpack"
+	channel = NewSqliteChannel(c)
+	current := NewEvent()
+	current.Body = []byte("written as msgpack")
+	if err := channel.AddEvent(current); err != nil {
+		t.Fatalf("add event: %s", err)
+	}
+
+	n, events, err := channel.GetAll()
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 events, got %d (%v)", n, err)
+	}
+	if events[0].ID != old.ID || events[1].ID != current.ID {
+		t.Errorf("expected events %s and %s, got %s and %s", old.ID, current.ID, events[0].ID, events[1].ID)
+	}
+}
+
+func TestSqliteChannelMigrateCodecColumn(t *testing.T) {
+	c, channel := initSqliteChannelTest()
+	s := channel.(*SqliteChannel)
+	s.db.Exec("drop table queue")
+	s.db.Exec("create table queue (id integer primary key autoincrement, body BLOB)")
+	s.db.Exec("insert into queue (body) values (?)", []byte(`{"Headers":{},"Body":"b2xk"}`))
+	s.db.Close()
+
+	channel = NewSqliteChannel(c)
+	defer cleanupSqliteChannelTest(c, channel)
+
+	n, events, err := channel.GetAll()
+	if err != nil || n != 1 || string(events[0].Body) != "old" {
+		t.Fatalf("expected the old row, got %d %v (%v)", n, events, err)
+	}
+}
+
+func BenchmarkEventCodecs(b *testing.B) {
+	e := codecTestEvent()
+	e.Body = []byte(strings.Repeat("GET /index.html?q=collectord 200 ", 8))
+
+	for _, name := range codecNames {
+		codec := NewEventCodec(name)
+		b.Run(name, func(b *testing.B) {
+			size := 0
+			for i := 0; i < b.N; i++ {
+				encoded, err := codec.Encode(e)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var decoded Event
+				if err := codec.Decode(encoded, &decoded); err != nil {
+					b.Fatal(err)
+				}
+				size = len(encoded)
+			}
+			b.ReportMetric(float64(size), "bytes/event")
+		})
+	}
+}
+
+func BenchmarkSqliteChannelCodecs(b *testing.B) {
+	events := make([]Event, 100)
+	for i := range events {
+		events[i] = codecTestEvent()
+		events[i].Body = []byte(strings.Repeat("GET /index.html?q=collectord 200 ", 8))
+	}
+
+	for _, name := range codecNames {
+		b.Run(name, func(b *testing.B) {
+			c, channel := initSqliteChannelCodecTest(name)
+			defer cleanupSqliteChannelTest(c, channel)
+			s := channel.(*SqliteChannel)
+
+			for i := 0; i < b.N; i++ {
+				if err := channel.AddEvents(events); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			var size int64
+			if err := s.db.QueryRow("select sum(length(body)) from queue").Scan(&size); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(size)/float64(b.N*len(events)), "bytes/event")
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression types for sinks writing files
+const (
+	COMPRESSION_NONE = "none"
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_ZSTD = "zstd"
+)
+
+// compressionExtensions is added to the names of compressed files
+var compressionExtensions = map[string]string{
+	COMPRESSION_NONE: "",
+	COMPRESSION_GZIP: ".gz",
+	COMPRESSION_ZSTD: ".zst",
+}
+
+// newCompressor wraps w in a compressor.  Closing it finishes the
+// compressed stream but leaves w open.
+func newCompressor(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case COMPRESSION_GZIP:
+		return gzip.NewWriter(w), nil
+	case COMPRESSION_ZSTD:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
@@ -12,10 +12,11 @@ import (
 )
 
 type Config struct {
-	Sinks    []map[string]string `json:"sinks"`
-	Sources  []map[string]string `json:"sources"`
-	Channels []map[string]string `json:"channels"`
-	Location string
+	Sinks        []map[string]string `json:"sinks"`
+	Sources      []map[string]string `json:"sources"`
+	Channels     []map[string]string `json:"channels"`
+	Interceptors []map[string]string `json:"interceptors"`
+	Location     string
 }
 
 type ComponentSettings map[string]string
@@ -25,6 +26,7 @@ var config Config
 var sinkLookup map[string]Sink
 var channelLookup map[string]Channel
 var sourceLookup map[string]Source
+var interceptorLookup map[string]Interceptor
 
 func init() {
 	confUsage := fmt.Sprintf("Set the config file.  This can also be set by the environment variable %s", CONFIG_ENV)
@@ -32,6 +34,13 @@ func init() {
 }
 
 func SetupConfig() {
+	setConfigLocation()
+	loadConfig()
+}
+
+// setConfigLocation finds the config file from the command line or
+// environment
+func setConfigLocation() {
 	if config.Location == "" {
 		// No config specified on command line, try environment variable
 		config.Location = os.Getenv(CONFIG_ENV)
@@ -44,14 +53,13 @@ func SetupConfig() {
 	if _, err := os.Stat(config.Location); os.IsNotExist(err) {
 		log.Fatalf("Config file does not exist: %s", config.Location)
 	}
-
-	loadConfig()
 }
 
-func loadConfig() {
+func readConfig() {
 	sinkLookup = make(map[string]Sink)
 	channelLookup = make(map[string]Channel)
 	sourceLookup = make(map[string]Source)
+	interceptorLookup = make(map[string]Interceptor)
 
 	rawConfig, err := ioutil.ReadFile(config.Location)
 	if err != nil {
@@ -60,8 +68,31 @@ func loadConfig() {
 	if err = json.Unmarshal(rawConfig, &config); err != nil {
 		log.Fatalf("Error reading config json: %s", err)
 	}
+}
+
+func loadConfig() {
+	readConfig()
 
 	// init components
+	for _, interceptorSettings := range config.Interceptors {
+		name, ok := interceptorSettings["name"]
+		if !ok {
+			logMissingField("Interceptor", "name")
+		}
+
+		_, exists := interceptorLookup[name]
+		if exists {
+			log.Fatalf("Duplicate interceptor name in config: %s", name)
+		}
+
+		itype, ok := interceptorSettings["type"]
+		if !ok {
+			logMissingField("Interceptor", "type")
+		}
+
+		interceptorLookup[name] = NewInterceptor(itype, interceptorSettings)
+	}
+
 	for _, sourceSettings := range config.Sources {
 		name, ok := sourceSettings["name"]
 		if !ok {
@@ -102,25 +133,7 @@ func loadConfig() {
 		sinkLookup[name] = sink
 	}
 
-	for _, channelSettings := range config.Channels {
-		name, ok := channelSettings["name"]
-		if !ok {
-			logMissingField("Channel", "name")
-		}
-
-		_, exists := channelLookup[name]
-		if exists {
-			log.Fatalf("Duplicate channel name in config: %s", name)
-		}
-
-		ctype, ok := channelSettings["type"]
-		if !ok {
-			logMissingField("Channel", "type")
-		}
-
-		channel := NewChannel(ctype, channelSettings)
-		channelLookup[name] = channel
-	}
+	createChannels()
 
 	// set up bindings
 	for _, sourceSettings := range config.Sources {
@@ -140,9 +153,21 @@ func loadConfig() {
 			channels = append(channels, channel)
 		}
 
+		interceptors := make([]Interceptor, 0)
+		for _, interceptorName := range splitList(sourceSettings["interceptors"]) {
+			interceptor, exists := interceptorLookup[interceptorName]
+			if !exists {
+				log.Fatalf("Config for source named %s has invalid interceptor %s", name, interceptorName)
+			}
+			interceptors = append(interceptors, interceptor)
+		}
+
 		source := sourceLookup[name]
 
 		for _, channel := range channels {
+			if len(interceptors) > 0 {
+				channel = &interceptedChannel{channel, interceptors}
+			}
 			source.SetChannel(channel)
 		}
 	}
@@ -159,26 +184,123 @@ func loadConfig() {
 			log.Fatalf("Config for sink named %s has invalid channel %s", name, channelName)
 		}
 
+		if consumers, ok := channel.(ConsumerChannel); ok {
+			consumerName := name
+			if c, ok := sinkSettings["consumer"]; ok {
+				consumerName = c
+			}
+			var err error
+			if channel, err = consumers.Consumer(consumerName); err != nil {
+				log.Fatalf("Config for sink named %s: %s", name, err)
+			}
+		}
+
 		sink := sinkLookup[name]
 		sink.SetChannel(channel)
+
+		if deadLetterName, ok := sinkSettings["dead_letter"]; ok {
+			setDeadLetter("sink", name, sink, deadLetterName)
+		}
 	}
 
 	// start the channels first
-	for _, channel := range channelLookup {
-		channel.Start()
+	for name, channel := range channelLookup {
+		if err := channel.Start(); err != nil {
+			log.Fatalf("Failed to start channel %s: %s", name, err)
+		}
 	}
 
-	for _, sink := range sinkLookup {
-		sink.Start()
+	for name, sink := range sinkLookup {
+		if err := sink.Start(); err != nil {
+			log.Fatalf("Failed to start sink %s: %s", name, err)
+		}
 	}
 
-	for _, source := range sourceLookup {
-		source.Start()
+	for name, source := range sourceLookup {
+		if err := source.Start(); err != nil {
+			log.Fatalf("Failed to start source %s: %s", name, err)
+		}
 	}
 
 	go ConfigReloader()
 }
 
+// StopComponents gives sources and sinks a chance to finish up before the
+// collector exits, then logs the events each channel expired or dropped
+func StopComponents() {
+	for name, source := range sourceLookup {
+		if stopper, ok := source.(Stopper); ok {
+			if err := stopper.Stop(); err != nil {
+				log.Printf("Failed to stop source %s: %s", name, err)
+			}
+		}
+	}
+	for name, sink := range sinkLookup {
+		if stopper, ok := sink.(Stopper); ok {
+			if err := stopper.Stop(); err != nil {
+				log.Printf("Failed to stop sink %s: %s", name, err)
+			}
+		}
+	}
+	for name, channel := range channelLookup {
+		if expirer, ok := channel.(Expirer); ok {
+			if stats := expirer.Stats(); stats.Expired > 0 || stats.Dropped > 0 {
+				log.Printf("Channel %s expired %d and dropped %d events", name, stats.Expired, stats.Dropped)
+			}
+		}
+	}
+}
+
+func createChannels() {
+	for _, channelSettings := range config.Channels {
+		name, ok := channelSettings["name"]
+		if !ok {
+			logMissingField("Channel", "name")
+		}
+
+		_, exists := channelLookup[name]
+		if exists {
+			log.Fatalf("Duplicate channel name in config: %s", name)
+		}
+
+		ctype, ok := channelSettings["type"]
+		if !ok {
+			logMissingField("Channel", "type")
+		}
+
+		channel := NewChannel(ctype, channelSettings)
+		channelLookup[name] = channel
+	}
+
+	for _, channelSettings := range config.Channels {
+		if deadLetterName, ok := channelSettings["dead_letter"]; ok {
+			name := channelSettings["name"]
+			setDeadLetter("channel", name, channelLookup[name], deadLetterName)
+		}
+	}
+}
+
+// setDeadLetter binds a component to the dead letter channel named in its
+// config.  Dead letter channels can't have dead letter channels of their
+// own, which rules out loops.
+func setDeadLetter(componentType string, name string, component interface{}, deadLetterName string) {
+	deadLetter, exists := channelLookup[deadLetterName]
+	if !exists {
+		log.Fatalf("Config for %s named %s has invalid dead_letter %s", componentType, name, deadLetterName)
+	}
+	for _, other := range config.Channels {
+		if other["name"] == deadLetterName && other["dead_letter"] != "" {
+			log.Fatalf("Config for %s named %s: dead_letter %s has a dead_letter itself", componentType, name, deadLetterName)
+		}
+	}
+
+	deadLetterer, ok := component.(DeadLetterer)
+	if !ok {
+		log.Fatalf("The %s named %s doesn't support dead_letter", componentType, name)
+	}
+	deadLetterer.SetDeadLetter(deadLetter)
+}
+
 func logMissingField(componentType string, field string) {
 	log.Fatalf("%s missing %s field in config", componentType, field)
 }
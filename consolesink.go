@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"os"
 	"time"
 )
 
 func init() {
-	RegisterSink("console", func(ComponentSettings) Sink { return &ConsoleSink{} })
+	RegisterSink("console", NewConsoleSink)
 }
 
+// ConsoleSink logs events, or writes them to stdout with the serializer
+// setting
 type ConsoleSink struct {
-	channel Channel
+	channel    Channel
+	serializer Serializer
+}
+
+func NewConsoleSink(config ComponentSettings) Sink {
+	c := &ConsoleSink{}
+	if s, ok := config["serializer"]; ok {
+		c.serializer = NewSerializer(s, config)
+	}
+	return c
 }
 
 func (c *ConsoleSink) SetChannel(ch Channel) error {
@@ -19,22 +33,38 @@ func (c *ConsoleSink) SetChannel(ch Channel) error {
 }
 
 func (c *ConsoleSink) Start() error {
+	if c.channel == nil {
+		return errors.New("consolesink: no channel set")
+	}
 	go c.loopForever()
 	return nil
 }
 
 func (c *ConsoleSink) loopForever() {
-	tick := time.Tick(time.Millisecond * 500)
-	for _ = range tick {
-		if c.channel == nil {
+	if c.serializer != nil {
+		os.Stdout.Write(c.serializer.Begin())
+	}
+	for {
+		if !c.channel.WaitForEvents(context.Background(), SINK_IDLE_TIMEOUT) {
 			continue
 		}
 		count, events, err := c.channel.GetAll()
 		if err != nil {
-			log.Printf("Error getting eventss: %s", err)
+			log.Printf("Error getting events: %s", err)
+			time.Sleep(SINK_RETRY_BACKOFF)
+			continue
 		}
 		for _, event := range events {
-			log.Printf("headers: %+v body: %s", event.Headers, event.Body)
+			if c.serializer == nil {
+				log.Printf("headers: %+v body: %s", event.Headers, event.Body)
+				continue
+			}
+			record, err := c.serializer.Serialize(event)
+			if err != nil {
+				log.Printf("consolesink: serialize: %s", err)
+				continue
+			}
+			os.Stdout.Write(record)
 		}
 		c.channel.ConfirmGet(count)
 	}
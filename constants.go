@@ -1,5 +1,9 @@
 package main
 
+import (
+	"time"
+)
+
 // file paths
 
 const (
@@ -16,4 +20,86 @@ const (
 
 const (
 	MIN_PACKET_THRESHOLD = 4096
+	// largest syslog message accepted, RFC 5425 recommends at least 8k
+	SYSLOG_MAX_MESSAGE_SIZE = 64 * 1024
+	// default longest record accepted by the tcp and udp sources
+	LINE_MAX_LENGTH = 64 * 1024
+	// default largest request body accepted by the http source
+	HTTP_MAX_BODY_SIZE = 10 * 1024 * 1024
+	// seconds clients are asked to wait when a channel is full
+	HTTP_RETRY_AFTER = 5
+	// seconds browsers may cache a CORS preflight response
+	HTTP_CORS_MAX_AGE = 600
+)
+
+// file source constants
+
+const (
+	// lines longer than this are split into multiple events
+	TAIL_MAX_LINE_LENGTH = 1024 * 1024
+	// most events read from a single file before moving to the next
+	TAIL_MAX_BATCH = 1000
+)
+
+// channel constants
+
+const (
+	// most events a sqlite channel returns from a single read
+	SQLITE_MAX_READ = 10000
+	// rows written by each multi-row insert statement
+	SQLITE_INSERT_BATCH = 100
+	// milliseconds sqlite waits on a locked database before failing
+	SQLITE_BUSY_TIMEOUT = 5000
+	// longest time between checks for events past a channel's max_age
+	CHANNEL_EXPIRE_INTERVAL = 10 * time.Second
+)
+
+// events moved at a time when replaying a dead letter channel
+const REPLAY_BATCH = 1000
+
+// sink constants
+
+const (
+	// how long sinks block waiting for events before re-checking state
+	SINK_IDLE_TIMEOUT = 5 * time.Second
+	// how long sinks back off after a failed read or send
+	SINK_RETRY_BACKOFF = 500 * time.Millisecond
+	// default most files a file sink keeps open at once
+	FILE_MAX_OPEN = 64
+	// default time a file sink keeps a file open without writing to it
+	FILE_IDLE_TIMEOUT = time.Minute
+	// default most events an http sink sends in one request
+	HTTP_SINK_BATCH = 100
+	// default time an http sink waits for a response
+	HTTP_SINK_TIMEOUT = 30 * time.Second
+	// longest an http sink backs off between retries, unless asked to wait
+	// longer with Retry-After
+	HTTP_SINK_MAX_BACKOFF = time.Minute
+	// default most events an elasticsearch sink indexes in one bulk request
+	ELASTICSEARCH_BATCH = 500
+	// default most events a redis sink pipelines at once
+	REDIS_SINK_BATCH = 100
+	// default time a redis sink waits to connect or for a batch's replies
+	REDIS_SINK_TIMEOUT = 10 * time.Second
+	// default most events a sql sink inserts in one transaction
+	SQL_SINK_BATCH = 500
+	// rows written by each multi-row insert statement of a sql sink, kept
+	// well under the databases' limits on bound parameters
+	SQL_INSERT_ROWS = 100
+)
+
+// kafka constants
+
+const (
+	// protocol version assumed of brokers unless configured, the first to
+	// support zstd compression
+	KAFKA_VERSION = "2.1.0"
+	// default most events a kafka sink produces, or a kafka source adds to
+	// its channels, at once
+	KAFKA_BATCH = 100
+	// how long the kafka source waits before retrying a full channel or a
+	// lost connection
+	KAFKA_RETRY_BACKOFF = time.Second
+	// record header carrying the event ID between collectors
+	KAFKA_EVENT_ID_HEADER = "EventID"
 )
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers describing why an event was sent to a dead letter channel.  All
+// of them start with DEAD_LETTER_HEADER_PREFIX so replays can remove them.
+const (
+	DEAD_LETTER_HEADER_PREFIX = "DeadLetter"
+	// "expired" or "failed"
+	DEAD_LETTER_REASON = "DeadLetterReason"
+	// the channel an expired event was queued in
+	DEAD_LETTER_CHANNEL = "DeadLetterChannel"
+	// the sink that failed to deliver an event, its last error and how
+	// many times it tried
+	DEAD_LETTER_SINK     = "DeadLetterSink"
+	DEAD_LETTER_ERROR    = "DeadLetterError"
+	DEAD_LETTER_ATTEMPTS = "DeadLetterAttempts"
+)
+
+// DeadLetterer is implemented by channels and sinks that can route events
+// they give up on to a dead letter channel, set with the dead_letter
+// setting.
+type DeadLetterer interface {
+	SetDeadLetter(Channel)
+}
+
+// deliveries counts a sink's failed attempts to deliver each event, by
+// event ID.  Once an event has failed max_attempts times it's moved to the
+// sink's dead letter channel (or dropped if there isn't one) and skipped
+// from then on, so one poisoned event can't block the events behind it.
+// Only failures caused by the event itself should be counted, not broken
+// connections.  Without max_attempts failed events are retried forever, but
+// events rejected outright are given up on whatever max_attempts is.
+type deliveries struct {
+	lock        sync.Mutex
+	component   string
+	sink        string
+	maxAttempts int
+	deadLetter  Channel
+	attempts    map[string]int
+	skipped     map[string]bool
+}
+
+func newDeliveries(component string, config ComponentSettings) *deliveries {
+	d := &deliveries{
+		component: component,
+		sink:      config["name"],
+		attempts:  make(map[string]int),
+		skipped:   make(map[string]bool),
+	}
+
+	if max, ok := config["max_attempts"]; ok {
+		var err error
+		if d.maxAttempts, err = strconv.Atoi(max); err != nil || d.maxAttempts < 0 {
+			log.Fatalf("%s: invalid max_attempts %s", component, max)
+		}
+	}
+
+	return d
+}
+
+func (d *deliveries) SetDeadLetter(channel Channel) {
+	d.deadLetter = channel
+}
+
+// failed records a failed attempt to deliver e, returning true if the sink
+// should give up on it.
+func (d *deliveries) failed(e Event, err error) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.maxAttempts == 0 {
+		return false
+	}
+	d.attempts[e.ID]++
+	if d.attempts[e.ID] < d.maxAttempts {
+		return false
+	}
+	return d.giveUp(e, err)
+}
+
+// rejected gives up on events straight away, for failures that retrying
+// can't fix, such as a server rejecting them as invalid.  It returns false
+// if they couldn't all be moved to the dead letter channel, in which case
+// they should be retried anyway; the ones already moved are skipped then.
+func (d *deliveries) rejected(events []Event, err error) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, e := range events {
+		if d.skipped[e.ID] {
+			continue
+		}
+		d.attempts[e.ID]++
+		if !d.giveUp(e, err) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *deliveries) giveUp(e Event, err error) bool {
+	attempts := d.attempts[e.ID]
+	if d.deadLetter == nil {
+		log.Printf("%s: dropping event %s after %d attempts: %s", d.sink, e.ID, attempts, err)
+	} else {
+		dead := e.Clone()
+		dead.Headers[DEAD_LETTER_REASON] = "failed"
+		dead.Headers[DEAD_LETTER_SINK] = d.sink
+		dead.Headers[DEAD_LETTER_ERROR] = err.Error()
+		dead.SetHeader(DEAD_LETTER_ATTEMPTS, IntValue(int64(attempts)))
+		if err := d.deadLetter.AddEvent(dead); err != nil {
+			log.Printf("%s: failed to dead letter event %s: %s", d.sink, e.ID, err)
+			return false
+		}
+	}
+	d.skipped[e.ID] = true
+	return true
+}
+
+// skip reports whether the sink has given up on e
+func (d *deliveries) skip(e Event) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.skipped[e.ID]
+}
+
+// confirmed forgets about events once they've been confirmed in the channel
+func (d *deliveries) confirmed(events []Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, e := range events {
+		delete(d.attempts, e.ID)
+		delete(d.skipped, e.ID)
+	}
+}
+
+// deliverForever sends batches of up to batchSize events from channel until
+// ctx is cancelled.  send returns the events to try again, or an error if
+// the whole batch should be, and either way they're retried after a
+// backoff.  A batch is only confirmed once every event in it has been
+// delivered or given up on, so events that went through on their own aren't
+// sent again.  A batch still pending when ctx is cancelled is left in the
+// channel.
+func (d *deliveries) deliverForever(ctx context.Context, channel Channel, batchSize int, send func([]Event) ([]Event, error)) {
+	var backoff retryBackoff
+	for ctx.Err() == nil {
+		if !channel.WaitForEvents(ctx, SINK_IDLE_TIMEOUT) {
+			continue
+		}
+		count, events, err := channel.GetOldest(batchSize)
+		if err != nil {
+			log.Printf("%s: channel get oldest: %s", d.component, err)
+			sleepContext(ctx, SINK_RETRY_BACKOFF)
+			continue
+		}
+
+		pending := events
+		for len(pending) > 0 && ctx.Err() == nil {
+			retry, err := send(pending)
+			if err != nil {
+				wait := backoff.next(retryAfter(err))
+				log.Printf("%s: %s, retrying in %s", d.component, err, wait)
+				sleepContext(ctx, wait)
+				continue
+			}
+			if len(retry) > 0 {
+				wait := backoff.next(0)
+				log.Printf("%s: %d of %d events failed, retrying in %s", d.component, len(retry), len(pending), wait)
+				sleepContext(ctx, wait)
+			} else {
+				backoff.reset()
+			}
+			pending = retry
+		}
+		if len(pending) > 0 {
+			channel.ConfirmGet(0)
+			return
+		}
+		channel.ConfirmGet(count)
+		d.confirmed(events)
+	}
+}
+
+// sleepContext waits for d or until ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// stripDeadLetterHeaders returns a copy of e without the headers added when
+// it was dead lettered.
+func stripDeadLetterHeaders(e Event) Event {
+	e = e.Clone()
+	for name := range e.Headers {
+		if strings.HasPrefix(name, DEAD_LETTER_HEADER_PREFIX) {
+			delete(e.Headers, name)
+			delete(e.TypedHeaders, name)
+		}
+	}
+	return e
+}
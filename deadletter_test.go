@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeliveriesDeadLetter(t *testing.T) {
+	d := newDeliveries("test", ComponentSettings{"name": "out", "max_attempts": "2"})
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	d.SetDeadLetter(deadLetter)
+
+	e := makeDummyEvents(1)[0]
+	if d.failed(e, errors.New("boom")) || d.skip(e) {
+		t.Fatal("Expected the first failure to be retried")
+	}
+	if !d.failed(e, errors.New("boom")) || !d.skip(e) {
+		t.Fatal("Expected the event to be given up on after max_attempts")
+	}
+
+	n, dead, _ := deadLetter.GetAll()
+	if n != 1 || dead[0].ID != e.ID {
+		t.Fatalf("Expected the event in the dead letter channel, got %v", dead)
+	}
+	checkHeaders(t, dead[0], map[string]string{
+		"DeadLetterReason":   "failed",
+		"DeadLetterSink":     "out",
+		"DeadLetterError":    "boom",
+		"DeadLetterAttempts": "2",
+	})
+	if v, _ := dead[0].Header(DEAD_LETTER_ATTEMPTS); v.Type != IntType {
+		t.Errorf("Expected an int attempts header, got %v", v)
+	}
+
+	d.confirmed([]Event{e})
+	if d.skip(e) {
+		t.Error("Expected confirmed events to be forgotten")
+	}
+}
+
+func TestDeliveriesRetryForever(t *testing.T) {
+	d := newDeliveries("test", ComponentSettings{"name": "out"})
+	e := makeDummyEvents(1)[0]
+	for i := 0; i < 100; i++ {
+		if d.failed(e, errors.New("boom")) {
+			t.Fatal("Expected events to be retried forever without max_attempts")
+		}
+	}
+}
+
+func TestDeliveriesDropWithoutDeadLetter(t *testing.T) {
+	d := newDeliveries("test", ComponentSettings{"name": "out", "max_attempts": "1"})
+	e := makeDummyEvents(1)[0]
+	if !d.failed(e, errors.New("boom")) || !d.skip(e) {
+		t.Error("Expected the event to be dropped")
+	}
+}
+
+func TestDeliveriesRejectedPartly(t *testing.T) {
+	// no max_attempts, which doesn't stop rejected events being given up on
+	d := newDeliveries("test", ComponentSettings{"name": "out"})
+	deadLetter := NewMemoryChannel(ComponentSettings{"max_events": "1"})
+	d.SetDeadLetter(deadLetter)
+
+	events := makeDummyEvents(2)
+	if d.rejected(events, errors.New("invalid")) {
+		t.Fatal("Expected the second event not to fit in the dead letter channel")
+	}
+	if !d.skip(events[0]) || d.skip(events[1]) {
+		t.Error("Expected only the moved event to be skipped")
+	}
+
+	n, dead, _ := deadLetter.GetAll()
+	deadLetter.ConfirmGet(n)
+	if n != 1 || dead[0].ID != events[0].ID {
+		t.Fatalf("Expected event 0 dead lettered, got %d", n)
+	}
+	if !d.rejected(events, errors.New("invalid")) {
+		t.Fatal("Expected the retry to give up on the rest")
+	}
+	if n, dead, _ = deadLetter.GetAll(); n != 1 || dead[0].ID != events[1].ID {
+		t.Errorf("Expected only event 1 dead lettered on the retry, got %d", n)
+	}
+}
+
+func TestDeliverForever(t *testing.T) {
+	d := newDeliveries("test", ComponentSettings{"name": "out"})
+	channel := NewMemoryChannel(ComponentSettings{})
+	channel.AddEvents(makeDummyEvents(3))
+
+	// the first event goes through, the others fail until the sink stops
+	var lock sync.Mutex
+	delivered := make([]string, 0)
+	send := func(events []Event) ([]Event, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		retry := make([]Event, 0)
+		for _, e := range events {
+			if string(e.Body) == "Event 0" {
+				delivered = append(delivered, e.ID)
+			} else {
+				retry = append(retry, e)
+			}
+		}
+		if len(delivered) > 1 {
+			t.Errorf("Expected a delivered event not to be sent again")
+		}
+		return retry, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.deliverForever(ctx, channel, 2, send)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if n, _, _ := channel.GetAll(); n != 3 {
+		t.Errorf("Expected the unfinished batch left in the channel, got %d events", n)
+	}
+}
+
+func TestReplayEvents(t *testing.T) {
+	from := NewMemoryChannel(ComponentSettings{})
+	to := NewMemoryChannel(ComponentSettings{})
+
+	events := makeDummyEvents(3)
+	for i := range events {
+		events[i].Headers[DEAD_LETTER_REASON] = "failed"
+		events[i].SetHeader(DEAD_LETTER_ATTEMPTS, IntValue(3))
+	}
+	from.AddEvents(events)
+
+	replayed, err := replayEvents(from, to)
+	if err != nil || replayed != 3 {
+		t.Fatalf("Expected 3 events replayed, got %d: %v", replayed, err)
+	}
+	if n, _, _ := from.GetAll(); n != 0 {
+		t.Errorf("Expected the dead letter channel to be empty, got %d events", n)
+	}
+
+	n, got, _ := to.GetAll()
+	if n != 3 {
+		t.Fatalf("Expected 3 replayed events, got %d", n)
+	}
+	for i, e := range got {
+		if e.ID != events[i].ID {
+			t.Errorf("Expected event %s, got %s", events[i].ID, e.ID)
+		}
+		if _, ok := e.Header(DEAD_LETTER_ATTEMPTS); ok {
+			t.Errorf("Expected dead letter headers to be removed, got %v", e.Headers)
+		}
+		checkHeaders(t, e, map[string]string{"num": events[i].Headers["num"]})
+	}
+}
+
+func TestReplayEventsFull(t *testing.T) {
+	from := NewMemoryChannel(ComponentSettings{})
+	to := NewMemoryChannel(ComponentSettings{"max_events": "1"})
+	from.AddEvents(makeDummyEvents(2))
+
+	if _, err := replayEvents(from, to); err != ErrChannelFull {
+		t.Fatalf("Expected ErrChannelFull, got %v", err)
+	}
+	if n, _, _ := from.GetAll(); n != 2 {
+		t.Errorf("Expected events to stay in the dead letter channel, got %d", n)
+	}
+}
+
+func TestGobSinkDeadLetter(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	received := make(chan Event, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				dec := gob.NewDecoder(bufio.NewReader(conn))
+				for {
+					var e Event
+					if err := dec.Decode(&e); err != nil {
+						return
+					}
+					if !isDummyEvent(e) {
+						received <- e
+					}
+				}
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	sink := NewGobSink(ComponentSettings{
+		"name":         "gob",
+		"host":         "127.0.0.1",
+		"port":         port,
+		"max_attempts": "2",
+	})
+	channel := NewMemoryChannel(ComponentSettings{})
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetChannel(channel)
+	sink.(DeadLetterer).SetDeadLetter(deadLetter)
+
+	// time reserves a -1 minute zone offset for UTC so gob can't encode it
+	events := makeDummyEvents(2)
+	events[0].IngestTime = time.Now().In(time.FixedZone("poison", -60))
+	channel.AddEvents(events)
+	if err := sink.Start(); err != nil {
+		t.Fatalf("Failed to start sink: %s", err)
+	}
+
+	select {
+	case e := <-received:
+		if e.ID != events[1].ID {
+			t.Errorf("Expected the good event to be delivered, got %s", e.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the good event")
+	}
+
+	if !deadLetter.WaitForEvents(context.Background(), time.Second) {
+		t.Fatal("Expected the poisoned event in the dead letter channel")
+	}
+	_, dead, _ := deadLetter.GetAll()
+	if dead[0].ID != events[0].ID {
+		t.Errorf("Expected the poisoned event, got %s", dead[0].ID)
+	}
+	checkHeaders(t, dead[0], map[string]string{"DeadLetterSink": "gob", "DeadLetterAttempts": "2"})
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterSink("elasticsearch", NewElasticsearchSink)
+}
+
+// ElasticsearchSink indexes events with the bulk API of Elasticsearch or
+// OpenSearch at url, batch_size events at a time.
+//
+// Documents go to the index named by the index template, which uses the
+// file sink's template syntax: %Y, %m, %d and %H for the event's time (so
+// "logs-%Y.%m.%d" makes daily indices) and %{Name} for event headers.
+// Index names are lowercased and characters indices can't have replaced.
+//
+// Each document has the event's headers as fields, or just those listed in
+// the headers setting (as Name or Name:field to rename them), and its time
+// as @timestamp.  With body_format text (the default) the body goes in the
+// body_field field, message by default; with json a body holding a JSON
+// object has its fields added to the document, overriding headers of the
+// same name.  Documents are given the event's ID so retries don't index
+// an event twice.
+//
+// Items the cluster rejects with 429 or 5xx are retried on their own until
+// they're indexed.  Items with any other error are given up on and moved to
+// the dead letter channel if there is one, except that 409 conflicts for
+// the create action mean the document is already there.  Whole requests
+// that fail are retried with backoff, including after a 401 or 403 for bad
+// credentials, except that a 413 splits the request in two until a single
+// document that is still too large is given up on.
+type ElasticsearchSink struct {
+	*deliveries
+	channel    Channel
+	url        string
+	index      string
+	action     string
+	headers    map[string]string
+	bodyFormat string
+	bodyField  string
+	batchSize  int
+	username   string
+	password   string
+	client     *http.Client
+
+	// cancelled by Stop, which waits for done
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// bulkResponse is the part of a bulk API response the sink looks at
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+type bulkItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func NewElasticsearchSink(config ComponentSettings) Sink {
+	url, ok := config["url"]
+	if !ok {
+		log.Fatal("Must configure url for elasticsearch sink")
+	}
+
+	e := &ElasticsearchSink{
+		deliveries: newDeliveries("elasticsearchsink", config),
+		url:        strings.TrimSuffix(url, "/") + "/_bulk",
+		index:      "collectord-%Y.%m.%d",
+		action:     "index",
+		bodyFormat: "text",
+		bodyField:  "message",
+		batchSize:  ELASTICSEARCH_BATCH,
+		username:   config["username"],
+		password:   config["password"],
+		client:     &http.Client{Timeout: HTTP_SINK_TIMEOUT},
+	}
+
+	if index, ok := config["index"]; ok {
+		e.index = index
+	}
+	if _, err := expandTemplate(e.index, time.Now(), func(string) (string, bool) { return "", true }); err != nil {
+		log.Fatalf("elasticsearchsink: invalid index %s: %s", e.index, err)
+	}
+
+	if action, ok := config["action"]; ok {
+		if action != "index" && action != "create" {
+			log.Fatalf("elasticsearchsink: invalid action %s", action)
+		}
+		e.action = action
+	}
+
+	if headers, ok := config["headers"]; ok {
+		e.headers = make(map[string]string)
+		for _, header := range splitList(headers) {
+			parts := strings.SplitN(header, ":", 2)
+			field := parts[0]
+			if len(parts) == 2 {
+				field = strings.TrimSpace(parts[1])
+			}
+			e.headers[strings.TrimSpace(parts[0])] = field
+		}
+	}
+
+	if format, ok := config["body_format"]; ok {
+		if format != "text" && format != "json" {
+			log.Fatalf("elasticsearchsink: invalid body_format %s", format)
+		}
+		e.bodyFormat = format
+	}
+	if field, ok := config["body_field"]; ok {
+		e.bodyField = field
+	}
+
+	if size, ok := config["batch_size"]; ok {
+		var err error
+		if e.batchSize, err = strconv.Atoi(size); err != nil || e.batchSize <= 0 {
+			log.Fatalf("elasticsearchsink: invalid batch_size %s", size)
+		}
+	}
+	if timeout, ok := config["timeout"]; ok {
+		var err error
+		if e.client.Timeout, err = time.ParseDuration(timeout); err != nil || e.client.Timeout < 0 {
+			log.Fatalf("elasticsearchsink: invalid timeout %s", timeout)
+		}
+	}
+
+	return e
+}
+
+func (e *ElasticsearchSink) SetChannel(channel Channel) error {
+	e.channel = channel
+	return nil
+}
+
+func (e *ElasticsearchSink) Start() error {
+	if e.channel == nil {
+		return errors.New("elasticsearchsink: no channel set")
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		e.deliverForever(e.ctx, e.channel, e.batchSize, e.bulk)
+	}()
+	return nil
+}
+
+// Stop returns once the batch being indexed is done
+func (e *ElasticsearchSink) Stop() error {
+	if e.cancel == nil {
+		return nil
+	}
+	e.cancel()
+	<-e.done
+	return nil
+}
+
+// bulk indexes events in one request, returning the ones to try again.  An
+// error means the request as a whole failed.
+func (e *ElasticsearchSink) bulk(events []Event) ([]Event, error) {
+	var body bytes.Buffer
+	sent := make([]Event, 0, len(events))
+	for _, event := range events {
+		if e.skip(event) {
+			continue
+		}
+		doc, err := e.document(event)
+		if err != nil {
+			if e.failed(event, err) {
+				continue
+			}
+			return nil, err
+		}
+		action := map[string]map[string]string{
+			e.action: {"_index": e.indexName(event), "_id": event.ID},
+		}
+		json.NewEncoder(&body).Encode(action)
+		body.Write(doc)
+		body.WriteByte('\n')
+		sent = append(sent, event)
+	}
+	if len(sent) == 0 {
+		return nil, nil
+	}
+
+	response, err := e.post(body.Bytes())
+	if httpErr, ok := err.(*httpSinkError); ok && httpErr.StatusCode == http.StatusRequestEntityTooLarge {
+		if len(sent) > 1 {
+			return e.bulkHalves(sent)
+		}
+		// a single document the cluster won't take however often it's sent
+		if e.rejected(sent, err) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Items) != len(sent) {
+		return nil, fmt.Errorf("expected %d items in the response, got %d", len(sent), len(response.Items))
+	}
+
+	retry := make([]Event, 0)
+	for i, item := range response.Items {
+		result := item[e.action]
+		switch {
+		case result.Status >= 200 && result.Status < 300:
+		case result.Status == http.StatusConflict && e.action == "create":
+			// already indexed by an earlier attempt
+		case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+			retry = append(retry, sent[i])
+		default:
+			err := fmt.Errorf("status %d: %s", result.Status, result.Error)
+			if !e.rejected(sent[i:i+1], err) {
+				retry = append(retry, sent[i])
+			}
+		}
+	}
+	return retry, nil
+}
+
+// bulkHalves indexes events in two requests, for batches too large for the
+// cluster to take at once
+func (e *ElasticsearchSink) bulkHalves(events []Event) ([]Event, error) {
+	half := len(events) / 2
+	retry, err := e.bulk(events[:half])
+	if err != nil {
+		return nil, err
+	}
+	rest, err := e.bulk(events[half:])
+	if err != nil {
+		return nil, err
+	}
+	return append(retry, rest...), nil
+}
+
+func (e *ElasticsearchSink) post(body []byte) (*bulkResponse, error) {
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, HTTP_MAX_BODY_SIZE))
+		return nil, newHttpSinkError(resp)
+	}
+
+	response := &bulkResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, fmt.Errorf("reading bulk response: %s", err)
+	}
+	return response, nil
+}
+
+// document maps an event to the JSON document indexed for it
+func (e *ElasticsearchSink) document(event Event) ([]byte, error) {
+	doc := make(map[string]interface{})
+	for name, value := range event.Headers {
+		if e.headers == nil {
+			doc[name] = value
+		} else if field, ok := e.headers[name]; ok {
+			doc[field] = value
+		}
+	}
+	doc["@timestamp"] = FormatTimestamp(eventTimeOrNow(event, time.Now()), TIMESTAMP_RFC3339_MS)
+
+	var fields map[string]interface{}
+	if e.bodyFormat == "json" && json.Unmarshal(event.Body, &fields) == nil {
+		for name, value := range fields {
+			doc[name] = value
+		}
+	} else {
+		doc[e.bodyField] = string(event.Body)
+	}
+	return json.Marshal(doc)
+}
+
+func (e *ElasticsearchSink) indexName(event Event) string {
+	name, _ := expandTemplate(e.index, eventTimeOrNow(event, time.Now()), func(header string) (string, bool) {
+		return event.Headers[header], true
+	})
+	return indexSafe.Replace(strings.ToLower(name))
+}
+
+// indexSafe replaces the characters Elasticsearch doesn't allow in index
+// names
+var indexSafe = strings.NewReplacer(`\`, "_", "/", "_", "*", "_", "?", "_", `"`, "_",
+	"<", "_", ">", "_", "|", "_", " ", "_", ",", "_", "#", "_", ":", "_")
+
+func (e *ElasticsearchSink) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
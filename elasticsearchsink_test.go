@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBulkServer answers bulk requests, giving each document the status
+// returned by statuses for its body, and records the actions and documents
+// it was sent
+type fakeBulkServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	statuses func(doc map[string]interface{}, attempt int) int
+	attempts map[string]int
+	actions  []map[string]map[string]string
+	docs     []map[string]interface{}
+	requests []*http.Request
+}
+
+func newFakeBulkServer(statuses func(doc map[string]interface{}, attempt int) int) *fakeBulkServer {
+	f := &fakeBulkServer{statuses: statuses, attempts: make(map[string]int)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		f.requests = append(f.requests, r)
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		items := make([]map[string]bulkItemResult, 0)
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			var doc map[string]interface{}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+			json.Unmarshal(scanner.Bytes(), &doc)
+			f.actions = append(f.actions, action)
+			f.docs = append(f.docs, doc)
+
+			for name, meta := range action {
+				f.attempts[meta["_id"]]++
+				status := f.statuses(doc, f.attempts[meta["_id"]])
+				result := bulkItemResult{Status: status}
+				if status >= 300 {
+					result.Error = json.RawMessage(fmt.Sprintf(`{"type":"error_%d"}`, status))
+				}
+				items = append(items, map[string]bulkItemResult{name: result})
+			}
+		}
+		json.NewEncoder(w).Encode(bulkResponse{Errors: true, Items: items})
+	}))
+	return f
+}
+
+func allIndexed(doc map[string]interface{}, attempt int) int {
+	return http.StatusCreated
+}
+
+func TestElasticsearchSinkDocuments(t *testing.T) {
+	server := newFakeBulkServer(allIndexed)
+	defer server.Close()
+
+	sink := NewElasticsearchSink(ComponentSettings{
+		"url":         server.URL + "/",
+		"index":       "Logs-%{app}-%Y.%m.%d",
+		"headers":     "num:number, app",
+		"body_format": "json",
+		"username":    "elastic",
+		"password":    "secret",
+	}).(*ElasticsearchSink)
+
+	events := makeDummyEvents(2)
+	events[0].Headers["app"] = "Web Server"
+	events[0].Headers["Timestamp"] = "2026-10-18T14:00:00.5Z"
+	events[0].Headers["host"] = "not mapped"
+	events[1].Headers["app"] = "db"
+	events[1].Headers["Timestamp"] = "2026-10-19T00:00:00Z"
+	events[1].Body = []byte(`{"level": "warn", "count": 3}`)
+
+	if retry, err := sink.bulk(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event indexed, got %d to retry: %v", len(retry), err)
+	}
+
+	if user, password, _ := server.requests[0].BasicAuth(); user != "elastic" || password != "secret" {
+		t.Errorf("Wrong credentials %s:%s", user, password)
+	}
+	expectedIndices := []string{"logs-web_server-2026.10.18", "logs-db-2026.10.19"}
+	for i, action := range server.actions {
+		meta := action["index"]
+		if meta["_index"] != expectedIndices[i] || meta["_id"] != events[i].ID {
+			t.Errorf("Wrong action %v", action)
+		}
+	}
+
+	doc := server.docs[0]
+	if doc["message"] != "Event 0" || doc["number"] != "0" || doc["app"] != "Web Server" ||
+		doc["@timestamp"] != "2026-10-18T14:00:00.500Z" {
+		t.Errorf("Wrong document %v", doc)
+	}
+	if _, ok := doc["host"]; ok {
+		t.Errorf("Expected only mapped headers, got %v", doc)
+	}
+	doc = server.docs[1]
+	if doc["level"] != "warn" || doc["count"] != 3.0 || doc["app"] != "db" {
+		t.Errorf("Expected the JSON body merged in, got %v", doc)
+	}
+	if _, ok := doc["message"]; ok {
+		t.Errorf("Expected no message field, got %v", doc)
+	}
+}
+
+func TestElasticsearchSinkRetriesRejectedItems(t *testing.T) {
+	// "Event 1" is throttled once, "Event 2" fails twice
+	server := newFakeBulkServer(func(doc map[string]interface{}, attempt int) int {
+		switch {
+		case doc["message"] == "Event 1" && attempt == 1:
+			return http.StatusTooManyRequests
+		case doc["message"] == "Event 2" && attempt < 3:
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusCreated
+	})
+	defer server.Close()
+
+	sink := NewElasticsearchSink(ComponentSettings{"url": server.URL}).(*ElasticsearchSink)
+	events := makeDummyEvents(3)
+
+	retry, err := sink.bulk(events)
+	if err != nil || len(retry) != 2 || retry[0].ID != events[1].ID || retry[1].ID != events[2].ID {
+		t.Fatalf("Expected events 1 and 2 retried, got %d: %v", len(retry), err)
+	}
+	if retry, err = sink.bulk(retry); err != nil || len(retry) != 1 || retry[0].ID != events[2].ID {
+		t.Fatalf("Expected event 2 retried, got %d: %v", len(retry), err)
+	}
+	if retry, err = sink.bulk(retry); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event indexed, got %d: %v", len(retry), err)
+	}
+	if len(server.docs) != 6 {
+		t.Errorf("Expected only rejected items resent, got %d documents", len(server.docs))
+	}
+}
+
+func TestElasticsearchSinkDeadLetter(t *testing.T) {
+	server := newFakeBulkServer(func(doc map[string]interface{}, attempt int) int {
+		if doc["message"] == "Event 0" {
+			return http.StatusBadRequest
+		}
+		return http.StatusCreated
+	})
+	defer server.Close()
+
+	sink := NewElasticsearchSink(ComponentSettings{"name": "search", "url": server.URL}).(*ElasticsearchSink)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetDeadLetter(deadLetter)
+
+	events := makeDummyEvents(2)
+	if retry, err := sink.bulk(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected nothing to retry, got %d: %v", len(retry), err)
+	}
+	n, dead, _ := deadLetter.GetAll()
+	if n != 1 || dead[0].ID != events[0].ID {
+		t.Fatalf("Expected event 0 dead lettered, got %d", n)
+	}
+	checkHeaders(t, dead[0], map[string]string{
+		"DeadLetterSink":  "search",
+		"DeadLetterError": `status 400: {"type":"error_400"}`,
+	})
+
+	// given up on events aren't sent again
+	sink.bulk(events)
+	if len(server.docs) != 3 {
+		t.Errorf("Expected the dead lettered event skipped, got %d documents", len(server.docs))
+	}
+}
+
+func TestElasticsearchSinkCreateConflict(t *testing.T) {
+	server := newFakeBulkServer(func(doc map[string]interface{}, attempt int) int {
+		return http.StatusConflict
+	})
+	defer server.Close()
+
+	sink := NewElasticsearchSink(ComponentSettings{"url": server.URL, "action": "create"}).(*ElasticsearchSink)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetDeadLetter(deadLetter)
+
+	if retry, err := sink.bulk(makeDummyEvents(2)); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected conflicts to count as indexed, got %d: %v", len(retry), err)
+	}
+	if n, _, _ := deadLetter.GetAll(); n != 0 {
+		t.Errorf("Expected nothing dead lettered, got %d", n)
+	}
+	if _, ok := server.actions[0]["create"]; !ok {
+		t.Errorf("Expected create actions, got %v", server.actions[0])
+	}
+}
+
+func TestElasticsearchSinkRequestFailure(t *testing.T) {
+	server := newFakeHttpServer(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	sink := NewElasticsearchSink(ComponentSettings{"url": server.URL}).(*ElasticsearchSink)
+	_, err := sink.bulk(makeDummyEvents(1))
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("Expected the request to fail, got %v", err)
+	}
+	if wait := retryAfter(err); wait.Seconds() != 7 {
+		t.Errorf("Expected Retry-After to be honoured, got %s", wait)
+	}
+}
+
+func TestElasticsearchSinkUnauthorized(t *testing.T) {
+	server := newFakeHttpServer(http.StatusUnauthorized)
+	defer server.Close()
+
+	sink := NewElasticsearchSink(ComponentSettings{"url": server.URL}).(*ElasticsearchSink)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetDeadLetter(deadLetter)
+
+	// bad credentials are retried until someone fixes them
+	events := makeDummyEvents(2)
+	if _, err := sink.bulk(events); err == nil {
+		t.Fatal("Expected the batch to fail")
+	}
+	if n, _, _ := deadLetter.GetAll(); n != 0 {
+		t.Errorf("Expected nothing dead lettered, got %d", n)
+	}
+	if sink.skip(events[0]) || sink.skip(events[1]) {
+		t.Error("Expected the events not to be given up on")
+	}
+}
+
+func TestElasticsearchSinkSplitsLargeRequests(t *testing.T) {
+	// requests of more than one document are too large
+	var lock sync.Mutex
+	indexed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		lines := 0
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			lines++
+		}
+		if lines > 2 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		indexed++
+		items := []map[string]bulkItemResult{{"index": {Status: http.StatusCreated}}}
+		json.NewEncoder(w).Encode(bulkResponse{Items: items})
+	}))
+	defer server.Close()
+
+	sink := NewElasticsearchSink(ComponentSettings{"url": server.URL}).(*ElasticsearchSink)
+	if retry, err := sink.bulk(makeDummyEvents(3)); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event indexed, got %d to retry: %v", len(retry), err)
+	}
+	if indexed != 3 {
+		t.Errorf("Expected 3 single document requests, got %d", indexed)
+	}
+}
+
+func TestElasticsearchSinkLoop(t *testing.T) {
+	server := newFakeBulkServer(allIndexed)
+	defer server.Close()
+
+	channel := NewMemoryChannel(ComponentSettings{})
+	channel.AddEvents(makeDummyEvents(5))
+	sink := NewElasticsearchSink(ComponentSettings{"url": server.URL, "batch_size": "2"}).(*ElasticsearchSink)
+	sink.SetChannel(channel)
+	sink.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		server.lock.Lock()
+		requests, docs := len(server.requests), len(server.docs)
+		server.lock.Unlock()
+		if docs == 5 {
+			if requests != 3 {
+				t.Errorf("Expected 5 documents in 3 requests, got %d", requests)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out with %d documents indexed", docs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := sink.Stop(); err != nil {
+		t.Errorf("Failed to stop: %s", err)
+	}
+}
+
+func TestElasticsearchSinkDocumentTooLarge(t *testing.T) {
+	// the request and both halves are too large
+	tooLarge := http.StatusRequestEntityTooLarge
+	server := newFakeHttpServer(tooLarge, tooLarge, tooLarge)
+	defer server.Close()
+
+	sink := NewElasticsearchSink(ComponentSettings{"url": server.URL}).(*ElasticsearchSink)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetDeadLetter(deadLetter)
+
+	events := makeDummyEvents(2)
+	if retry, err := sink.bulk(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected the documents given up on, got %d to retry: %v", len(retry), err)
+	}
+	if n, _, _ := deadLetter.GetAll(); n != 2 {
+		t.Errorf("Expected both events dead lettered, got %d", n)
+	}
+	if !sink.skip(events[0]) || !sink.skip(events[1]) {
+		t.Error("Expected the events to be skipped")
+	}
+}
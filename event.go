@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// EVENT_VERSION is the current version of the Event model.  Version 0
+// events, from before events had IDs, ingest times and typed headers, only
+// carry Headers and Body and are upgraded as they are decoded.
+const EVENT_VERSION = 1
+
+type ValueType int
+
+const (
+	StringType ValueType = iota
+	IntType
+	FloatType
+	BoolType
+	BytesType
+	TimeType
+)
+
+var valueTypeNames = []string{"string", "int", "float", "bool", "bytes", "time"}
+
+func (t ValueType) String() string {
+	if int(t) < len(valueTypeNames) {
+		return valueTypeNames[t]
+	}
+	return fmt.Sprintf("ValueType(%d)", int(t))
+}
+
+// Value is a typed header value.  Only the field matching Type is used.
+type Value struct {
+	Type  ValueType
+	Str   string
+	Int   int64
+	Float float64
+	Bool  bool
+	Bytes []byte
+	Time  time.Time
+}
+
+func StringValue(s string) Value  { return Value{Type: StringType, Str: s} }
+func IntValue(i int64) Value      { return Value{Type: IntType, Int: i} }
+func FloatValue(f float64) Value  { return Value{Type: FloatType, Float: f} }
+func BoolValue(b bool) Value      { return Value{Type: BoolType, Bool: b} }
+func BytesValue(b []byte) Value   { return Value{Type: BytesType, Bytes: b} }
+func TimeValue(t time.Time) Value { return Value{Type: TimeType, Time: t.UTC()} }
+
+// String renders the value the way it appears in Event.Headers
+func (v Value) String() string {
+	switch v.Type {
+	case IntType:
+		return strconv.FormatInt(v.Int, 10)
+	case FloatType:
+		return strconv.FormatFloat(v.Float, 'g', -1, 64)
+	case BoolType:
+		return strconv.FormatBool(v.Bool)
+	case BytesType:
+		return base64.StdEncoding.EncodeToString(v.Bytes)
+	case TimeType:
+		return v.Time.Format(time.RFC3339Nano)
+	}
+	return v.Str
+}
+
+// jsonValue is the JSON form of a Value, {"type": "int", "value": 42}
+type jsonValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	var raw interface{}
+	switch v.Type {
+	case IntType:
+		raw = v.Int
+	case FloatType:
+		raw = v.Float
+	case BoolType:
+		raw = v.Bool
+	case BytesType:
+		raw = v.Bytes
+	case TimeType:
+		raw = v.Time.Format(time.RFC3339Nano)
+	default:
+		raw = v.Str
+	}
+	value, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonValue{Type: v.Type.String(), Value: value})
+}
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var jv jsonValue
+	if err := json.Unmarshal(data, &jv); err != nil {
+		return err
+	}
+
+	var err error
+	*v = Value{}
+	switch jv.Type {
+	case "string":
+		v.Type = StringType
+		err = json.Unmarshal(jv.Value, &v.Str)
+	case "int":
+		v.Type = IntType
+		err = json.Unmarshal(jv.Value, &v.Int)
+	case "float":
+		v.Type = FloatType
+		err = json.Unmarshal(jv.Value, &v.Float)
+	case "bool":
+		v.Type = BoolType
+		err = json.Unmarshal(jv.Value, &v.Bool)
+	case "bytes":
+		v.Type = BytesType
+		err = json.Unmarshal(jv.Value, &v.Bytes)
+	case "time":
+		var s string
+		if err = json.Unmarshal(jv.Value, &s); err == nil {
+			v.Type = TimeType
+			v.Time, err = time.Parse(time.RFC3339Nano, s)
+		}
+	default:
+		err = fmt.Errorf("unknown header value type %q", jv.Type)
+	}
+	return err
+}
+
+// SetHeader sets a typed header.  Every header also appears in Headers as a
+// string, so code that only knows about string headers still sees it.
+func (e *Event) SetHeader(name string, v Value) {
+	if e.Headers == nil {
+		e.Headers = make(map[string]string)
+	}
+	e.Headers[name] = v.String()
+
+	if v.Type == StringType {
+		delete(e.TypedHeaders, name)
+		return
+	}
+	if e.TypedHeaders == nil {
+		e.TypedHeaders = make(map[string]Value)
+	}
+	e.TypedHeaders[name] = v
+}
+
+// Header returns the typed value of a header, falling back to a string
+// value for headers that were set without a type.
+func (e Event) Header(name string) (Value, bool) {
+	if v, ok := e.TypedHeaders[name]; ok {
+		return v, true
+	}
+	if s, ok := e.Headers[name]; ok {
+		return StringValue(s), true
+	}
+	return Value{}, false
+}
+
+func newEventID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// fall back to something unique enough for this process
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// upgradeEvent brings an event decoded from an older stream or row up to
+// the current version.  Old events are given a new ID, unless the caller
+// already set a stable one, and their Timestamp (if any) as their ingest
+// time.
+func upgradeEvent(e *Event) {
+	if e.Headers == nil {
+		e.Headers = make(map[string]string)
+	}
+	if e.Body == nil {
+		e.Body = make([]byte, 0)
+	}
+	if e.Version >= EVENT_VERSION {
+		return
+	}
+
+	if e.ID == "" {
+		e.ID = newEventID()
+	}
+	if e.IngestTime.IsZero() {
+		if t, ok := EventTime(*e); ok {
+			e.IngestTime = t
+		}
+	}
+	e.Version = EVENT_VERSION
+}
+
+// storedEventID derives an ID for an old event from its contents and where
+// it's stored, so the same stored event always gets the same ID while
+// events that only have the same contents don't share one.
+func storedEventID(e Event, seq int) string {
+	names := make([]string, 0, len(e.Headers))
+	for name := range e.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00", seq)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\x00", name, e.Headers[name])
+	}
+	h.Write(e.Body)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Clone returns a copy of e whose header maps can be modified without
+// affecting e, which may have been added to several channels.
+func (e Event) Clone() Event {
+	headers := make(map[string]string, len(e.Headers))
+	for k, v := range e.Headers {
+		headers[k] = v
+	}
+	e.Headers = headers
+
+	if e.TypedHeaders != nil {
+		typed := make(map[string]Value, len(e.TypedHeaders))
+		for k, v := range e.TypedHeaders {
+			typed[k] = v
+		}
+		e.TypedHeaders = typed
+	}
+	return e
+}
+
+// Size approximates the memory used by an event's headers and body
+func (e Event) Size() int {
+	size := len(e.ID) + len(e.Body)
+	for k, v := range e.Headers {
+		size += len(k) + len(v)
+	}
+	return size
+}
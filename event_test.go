@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// v0Event is the Event struct as it was before versioning
+type v0Event struct {
+	Headers map[string]string
+	Body    []byte
+}
+
+func TestNewEvent(t *testing.T) {
+	a, b := NewEvent(), NewEvent()
+	if a.Version != EVENT_VERSION {
+		t.Errorf("expected version %d, got %d", EVENT_VERSION, a.Version)
+	}
+	if len(a.ID) != 32 || a.ID == b.ID {
+		t.Errorf("expected distinct 32 character ids, got %q and %q", a.ID, b.ID)
+	}
+	if a.IngestTime.IsZero() {
+		t.Error("expected ingest time to be set")
+	}
+}
+
+func TestEventTypedHeaders(t *testing.T) {
+	ts := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	e := NewEvent()
+	e.SetHeader("Count", IntValue(42))
+	e.SetHeader("Ratio", FloatValue(0.5))
+	e.SetHeader("Ok", BoolValue(true))
+	e.SetHeader("Raw", BytesValue([]byte{0, 1, 0xff}))
+	e.SetHeader("When", TimeValue(ts))
+	e.SetHeader("Name", StringValue("collectord"))
+
+	checkHeaders(t, e, map[string]string{
+		"Count": "42",
+		"Ratio": "0.5",
+		"Ok":    "true",
+		"Raw":   "AAH/",
+		"When":  "2026-10-19T12:00:00Z",
+		"Name":  "collectord",
+	})
+	if _, ok := e.TypedHeaders["Name"]; ok {
+		t.Error("string headers shouldn't be stored as typed headers")
+	}
+
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("marshal: %s", err)
+	}
+	var decoded Event
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("unmarshal: %s", err)
+	}
+
+	if v, _ := decoded.Header("Count"); v.Type != IntType || v.Int != 42 {
+		t.Errorf("expected int 42, got %+v", v)
+	}
+	if v, _ := decoded.Header("Ratio"); v.Type != FloatType || v.Float != 0.5 {
+		t.Errorf("expected float 0.5, got %+v", v)
+	}
+	if v, _ := decoded.Header("Ok"); v.Type != BoolType || !v.Bool {
+		t.Errorf("expected bool true, got %+v", v)
+	}
+	if v, _ := decoded.Header("Raw"); v.Type != BytesType || !bytes.Equal(v.Bytes, []byte{0, 1, 0xff}) {
+		t.Errorf("expected bytes, got %+v", v)
+	}
+	if v, _ := decoded.Header("When"); v.Type != TimeType || !v.Time.Equal(ts) {
+		t.Errorf("expected time %s, got %+v", ts, v)
+	}
+	if v, _ := decoded.Header("Name"); v.Type != StringType || v.Str != "collectord" {
+		t.Errorf("expected string, got %+v", v)
+	}
+	if _, ok := decoded.Header("Missing"); ok {
+		t.Error("expected missing header to be absent")
+	}
+	if decoded.ID != e.ID || decoded.Version != EVENT_VERSION || !decoded.IngestTime.Equal(e.IngestTime) {
+		t.Errorf("expected id, version and ingest time to survive, got %+v", decoded)
+	}
+}
+
+func TestEventBinaryBody(t *testing.T) {
+	e := NewEvent()
+	e.Body = []byte{0, '\n', 0xfe, 0xff, '\t'}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		t.Fatalf("gob encode: %s", err)
+	}
+	var decoded Event
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatalf("gob decode: %s", err)
+	}
+	if !bytes.Equal(decoded.Body, e.Body) {
+		t.Errorf("expected body %v, got %v", e.Body, decoded.Body)
+	}
+}
+
+func TestEventDecodeV0Gob(t *testing.T) {
+	old := v0Event{map[string]string{"Timestamp": "1414000000"}, []byte("hello")}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(old); err != nil {
+			t.Fatalf("gob encode: %s", err)
+		}
+	}
+
+	dec := gob.NewDecoder(&buf)
+	ids := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("gob decode: %s", err)
+		}
+		upgradeEvent(&e)
+
+		if e.Version != EVENT_VERSION || string(e.Body) != "hello" {
+			t.Errorf("unexpected upgraded event %+v", e)
+		}
+		if !e.IngestTime.Equal(time.Unix(1414000000, 0)) {
+			t.Errorf("expected ingest time from Timestamp, got %s", e.IngestTime)
+		}
+		ids = append(ids, e.ID)
+	}
+	if ids[0] == "" || ids[0] == ids[1] {
+		t.Errorf("expected distinct ids for identical events, got %v", ids)
+	}
+}
+
+func TestSqliteChannelDecodeV0Rows(t *testing.T) {
+	c, channel := initSqliteChannelTest()
+	defer cleanupSqliteChannelTest(c, channel)
+
+	row := `{"Headers":{"Host":"web1"},"Body":"aGVsbG8="}`
+	for i := 0; i < 2; i++ {
+		if _, err := channel.(*SqliteChannel).db.Exec("insert into queue(body) values(?)", []byte(row)); err != nil {
+			t.Fatalf("inserting old row: %s", err)
+		}
+	}
+
+	n, events, err := channel.GetAll()
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 events, got %d (%v)", n, err)
+	}
+	e := events[0]
+	if e.Version != EVENT_VERSION || e.ID == "" || string(e.Body) != "hello" || e.Headers["Host"] != "web1" {
+		t.Errorf("unexpected upgraded event %+v", e)
+	}
+	if events[1].ID == e.ID {
+		t.Errorf("expected identical rows to get distinct ids, got %s", e.ID)
+	}
+
+	// a row keeps its id when it's read again
+	channel.ConfirmGet(0)
+	if _, again, _ := channel.GetAll(); again[0].ID != e.ID {
+		t.Errorf("expected the same id on every read, got %s and %s", e.ID, again[0].ID)
+	}
+}
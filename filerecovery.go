@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Policies for files without any events, set with a file sink's
+// empty_files setting
+const (
+	EMPTY_FILES_DELETE     = "delete"
+	EMPTY_FILES_KEEP       = "keep"
+	EMPTY_FILES_QUARANTINE = "quarantine"
+)
+
+// recoverFiles deals with the .inc files a previous run left in the
+// incomplete directory, as happens after a crash.  Files written by a line
+// serializer are cut back to their last whole record and completed.  That
+// keeps every confirmed record, but records written after the last sync or
+// confirm may survive too, and as they were never confirmed they'll be
+// written again, so those events can end up in two files.  Anything else,
+// such as an Avro or body file, is moved to the quarantine directory
+// (quarantine in the incomplete directory by default) to be looked at by
+// hand.  Files without events are handled by the empty_files policy.
+func (f *FileSink) recoverFiles() error {
+	if _, err := os.Stat(f.incompletePath); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(f.incompletePath, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if name == f.quarantinePath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case strings.HasSuffix(name, ".inc.tmp"):
+			// a recovery that didn't finish, the .inc file is still there
+			return os.Remove(name)
+		case strings.HasSuffix(name, ".inc"):
+			if err := f.recoverFile(name); err != nil {
+				log.Printf("%s: recovering %s: %s", f.component, name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (f *FileSink) recoverFile(incName string) error {
+	rel, err := filepath.Rel(f.incompletePath, incName)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(rel, ".inc")
+
+	data, err := ioutil.ReadFile(incName)
+	if err != nil {
+		return err
+	}
+	compression := COMPRESSION_NONE
+	for c, ext := range compressionExtensions {
+		if ext != "" && strings.HasSuffix(name, ext) {
+			compression = c
+		}
+	}
+	contents, whole := decompressPartial(compression, data)
+
+	begin := f.serializer.Begin()
+	if len(contents) <= len(begin) && bytes.HasPrefix(begin, contents) {
+		return f.recoverEmpty(incName, name)
+	}
+	if !lineSerializers[f.serializerName] {
+		log.Printf("%s: quarantining %s", f.component, incName)
+		return f.quarantine(incName, name)
+	}
+
+	cut := contents[:bytes.LastIndexByte(contents, '\n')+1]
+	if len(cut) <= len(begin) {
+		return f.recoverEmpty(incName, name)
+	}
+	if compression != COMPRESSION_NONE || !whole || len(cut) < len(contents) {
+		if err := rewriteFile(incName, compression, cut); err != nil {
+			return err
+		}
+	}
+	log.Printf("%s: recovered %s", f.component, incName)
+	return f.complete(incName, name)
+}
+
+func (f *FileSink) recoverEmpty(incName string, name string) error {
+	switch f.emptyFiles {
+	case EMPTY_FILES_KEEP:
+		return f.complete(incName, name)
+	case EMPTY_FILES_QUARANTINE:
+		return f.quarantine(incName, name)
+	}
+	return os.Remove(incName)
+}
+
+// decompressPartial decompresses as much of a file as it can, reporting
+// whether the whole file was readable
+func decompressPartial(compression string, data []byte) ([]byte, bool) {
+	var r io.Reader
+	var err error
+	switch compression {
+	case COMPRESSION_GZIP:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case COMPRESSION_ZSTD:
+		var z *zstd.Decoder
+		if z, err = zstd.NewReader(bytes.NewReader(data)); err == nil {
+			defer z.Close()
+			r = z
+		}
+	default:
+		return data, true
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	var out bytes.Buffer
+	_, err = io.Copy(&out, r)
+	return out.Bytes(), err == nil
+}
+
+// rewriteFile replaces a file's contents, going through a temporary file so
+// a crash can't lose the original
+func rewriteFile(name string, compression string, contents []byte) error {
+	tmp, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	var out io.Writer = tmp
+	var compressor io.WriteCloser
+	if compression != COMPRESSION_NONE {
+		if compressor, err = newCompressor(compression, tmp); err != nil {
+			tmp.Close()
+			return err
+		}
+		out = compressor
+	}
+	if _, err = out.Write(contents); err == nil && compressor != nil {
+		err = compressor.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterSink("file", NewFileSink)
+	RegisterSink("legacy", NewLegacyFileSink)
+}
+
+// FileSink writes events to files in the incomplete directory, moving each
+// file to the complete directory once it's rolled, so anything picking up
+// files from complete only ever sees finished ones.  Files are rolled after
+// roll_events events, roll_bytes bytes or roll_interval since they were
+// opened, whichever comes first, or once nothing has been written to them
+// for idle_timeout.
+//
+// Files are named by the file_name template, relative to both directories.
+// Templates can use the strftime style %Y, %m, %d, %H, %M and %S for the
+// event's time (in UTC), %% for a literal %, and %{placeholder} for:
+//
+//	host     the machine's hostname
+//	sink     the sink's name
+//	seq      a number incremented for every file the sink opens
+//	unix     the time the file was opened in unix seconds
+//	unix_ms  the time the file was opened in unix milliseconds
+//
+// Any other placeholder is the value of the event header with that name,
+// such as %{Hostname}, with slashes replaced so it can't add directories.
+// Events whose templates expand to the same bucket, ignoring seq, unix and
+// unix_ms, share a file, so "%{Hostname}/%Y/%m/%d/%H/%{seq}.log" keeps a
+// file open per host and hour.  The default, "%{host}-%Y%m%d%H-%{seq}.log",
+// has one file open per hour of event time.  Up to max_open_files files are
+// open at once, the least recently written being rolled to make room for
+// more.
+//
+// With compression set to gzip or zstd files are compressed, with .gz or
+// .zst added to their names.  roll_bytes counts bytes before compression.
+//
+// Files are flushed and synced before events are confirmed in the channel
+// unless fsync is false.  Files left in the incomplete directory by a crash
+// are recovered when the sink starts, so each sink needs an incomplete
+// directory of its own; see recoverFiles.  A batch that fails part way
+// through is written again in full, so some of its events can be written
+// twice, even to files already moved to complete.  Stop rolls every open
+// file.
+//
+// A name already used by a file in either directory is never reused: seq is
+// bumped until the name is free or, for templates without %{seq}, a -N is
+// added before the extension.
+type FileSink struct {
+	*deliveries
+	component      string
+	channel        Channel
+	incompletePath string
+	completePath   string
+	fileName       string
+	serializerName string
+	serializer     Serializer
+	rollEvents     int
+	rollBytes      int64
+	rollInterval   time.Duration
+	compression    string
+	fsync          bool
+	emptyFiles     string
+	quarantinePath string
+	maxOpen        int
+	idleTimeout    time.Duration
+	hostname       string
+	seq            uint64
+
+	// open files by bucket, most recently written at the front of lru
+	buckets map[string]*fileBucket
+	lru     *list.List
+
+	// cancelled by Stop, which waits for done
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// fileBucket is the open file for one expansion of the file_name template
+type fileBucket struct {
+	key  string
+	name string
+	file *os.File
+	// file, or a compressor writing to it
+	out io.Writer
+	// written to since it was last synced
+	dirty     bool
+	events    int
+	bytes     int64
+	nextRoll  time.Time
+	lastWrite time.Time
+	element   *list.Element
+}
+
+func NewFileSink(config ComponentSettings) Sink {
+	return newFileSink("filesink", config)
+}
+
+// NewLegacyFileSink is a file sink with the settings the legacy sink was
+// hardcoded with: the five column TSV layout, rolled every 100 events or 10
+// seconds, in files named by the unix time.
+func NewLegacyFileSink(config ComponentSettings) Sink {
+	settings := ComponentSettings{
+		"serializer":    "legacy_tsv",
+		"roll_events":   "100",
+		"roll_interval": "10s",
+		"file_name":     "%{unix}.txt",
+	}
+	for k, v := range config {
+		settings[k] = v
+	}
+	return newFileSink("legacysink", settings)
+}
+
+func newFileSink(component string, config ComponentSettings) *FileSink {
+	f := &FileSink{
+		deliveries:  newDeliveries(component, config),
+		component:   component,
+		fileName:    "%{host}-%Y%m%d%H-%{seq}.log",
+		maxOpen:     FILE_MAX_OPEN,
+		idleTimeout: FILE_IDLE_TIMEOUT,
+		buckets:     make(map[string]*fileBucket),
+		lru:         list.New(),
+	}
+
+	var ok bool
+	if f.incompletePath, ok = config["incomplete"]; !ok {
+		log.Fatalf("Must configure incomplete path for %s", component)
+	}
+	if f.completePath, ok = config["complete"]; !ok {
+		log.Fatalf("Must configure complete path for %s", component)
+	}
+
+	if name, ok := config["file_name"]; ok {
+		f.fileName = name
+	}
+	var err error
+	if f.hostname, err = os.Hostname(); err != nil {
+		log.Fatalf("%s: can't get hostname: %s", component, err)
+	}
+	if _, err := expandTemplate(f.fileName, time.Now(), f.placeholders(NewEvent(), time.Now())); err != nil {
+		log.Fatalf("%s: invalid file_name %s: %s", component, f.fileName, err)
+	}
+
+	f.serializerName = "json"
+	if s, ok := config["serializer"]; ok {
+		f.serializerName = s
+	}
+	f.serializer = NewSerializer(f.serializerName, config)
+
+	if roll, ok := config["roll_events"]; ok {
+		if f.rollEvents, err = strconv.Atoi(roll); err != nil || f.rollEvents < 0 {
+			log.Fatalf("%s: invalid roll_events %s", component, roll)
+		}
+	}
+	if roll, ok := config["roll_bytes"]; ok {
+		if f.rollBytes, err = strconv.ParseInt(roll, 10, 64); err != nil || f.rollBytes < 0 {
+			log.Fatalf("%s: invalid roll_bytes %s", component, roll)
+		}
+	}
+	if roll, ok := config["roll_interval"]; ok {
+		if f.rollInterval, err = time.ParseDuration(roll); err != nil || f.rollInterval < 0 {
+			log.Fatalf("%s: invalid roll_interval %s", component, roll)
+		}
+	}
+
+	f.compression = COMPRESSION_NONE
+	if c, ok := config["compression"]; ok {
+		if _, ok := compressionExtensions[c]; !ok {
+			log.Fatalf("%s: invalid compression %s", component, c)
+		}
+		f.compression = c
+	}
+
+	switch config["fsync"] {
+	case "", "true":
+		f.fsync = true
+	case "false":
+	default:
+		log.Fatalf("%s: invalid fsync %s", component, config["fsync"])
+	}
+
+	f.emptyFiles = EMPTY_FILES_DELETE
+	if empty, ok := config["empty_files"]; ok {
+		switch empty {
+		case EMPTY_FILES_DELETE, EMPTY_FILES_KEEP, EMPTY_FILES_QUARANTINE:
+			f.emptyFiles = empty
+		default:
+			log.Fatalf("%s: invalid empty_files %s", component, empty)
+		}
+	}
+
+	f.quarantinePath = filepath.Join(f.incompletePath, "quarantine")
+	if q, ok := config["quarantine"]; ok {
+		f.quarantinePath = filepath.Clean(q)
+	}
+
+	if max, ok := config["max_open_files"]; ok {
+		if f.maxOpen, err = strconv.Atoi(max); err != nil || f.maxOpen < 0 {
+			log.Fatalf("%s: invalid max_open_files %s", component, max)
+		}
+	}
+	if idle, ok := config["idle_timeout"]; ok {
+		if f.idleTimeout, err = time.ParseDuration(idle); err != nil || f.idleTimeout <= 0 {
+			log.Fatalf("%s: invalid idle_timeout %s", component, idle)
+		}
+	}
+
+	return f
+}
+
+func (f *FileSink) SetChannel(channel Channel) error {
+	f.channel = channel
+	return nil
+}
+
+func (f *FileSink) Start() error {
+	if f.channel == nil {
+		return fmt.Errorf("%s: no channel set", f.component)
+	}
+	if err := f.recoverFiles(); err != nil {
+		return err
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.done = make(chan struct{})
+	go f.loopForever()
+	return nil
+}
+
+// Stop rolls every open file once the batch being written is done
+func (f *FileSink) Stop() error {
+	if f.cancel == nil {
+		return nil
+	}
+	f.cancel()
+	<-f.done
+	return nil
+}
+
+func (f *FileSink) loopForever() {
+	defer close(f.done)
+	for {
+		select {
+		case <-f.ctx.Done():
+			if err := f.rollAll(); err != nil {
+				log.Printf("%s: roll on stop: %s", f.component, err)
+			}
+			return
+		default:
+		}
+
+		timeout := SINK_IDLE_TIMEOUT
+		now := time.Now()
+		if next := f.rollDue(now); !next.IsZero() && next.Sub(now) < timeout {
+			timeout = next.Sub(now)
+		}
+
+		if !f.channel.WaitForEvents(f.ctx, timeout) {
+			continue
+		}
+		count, events, err := f.channel.GetAll()
+		if err != nil {
+			log.Printf("%s: channel get all: %s", f.component, err)
+			time.Sleep(SINK_RETRY_BACKOFF)
+			continue
+		}
+
+		// events are only confirmed once they're on disk
+		records, ok := f.serialize(events)
+		if ok {
+			if err = f.write(events, records); err == nil {
+				err = f.sync()
+			}
+		}
+		if !ok || err != nil {
+			if err != nil {
+				log.Printf("%s: write: %s", f.component, err)
+			}
+			f.channel.ConfirmGet(0)
+			time.Sleep(SINK_RETRY_BACKOFF)
+			continue
+		}
+		f.channel.ConfirmGet(count)
+		f.confirmed(events)
+	}
+}
+
+// serialize formats a batch up front so a bad event can't leave part of the
+// batch written.  Events the sink has given up on come back as nil.
+func (f *FileSink) serialize(events []Event) ([][]byte, bool) {
+	records := make([][]byte, len(events))
+	for i, event := range events {
+		if f.skip(event) {
+			continue
+		}
+		record, err := f.serializer.Serialize(event)
+		if err != nil {
+			log.Printf("%s: serialize: %s", f.component, err)
+			if f.failed(event, err) {
+				continue
+			}
+			return nil, false
+		}
+		records[i] = record
+	}
+	return records, true
+}
+
+// write appends each record to the file for its event's bucket, rolling
+// files that reach roll_events or roll_bytes as it goes.  If a later record
+// fails the whole batch is written again, so the events before it end up in
+// two files, or twice in the same one, much as after recoverFiles.
+func (f *FileSink) write(events []Event, records [][]byte) error {
+	now := time.Now()
+	for i, record := range records {
+		if record == nil {
+			continue
+		}
+		key, err := expandTemplate(f.fileName, eventTimeOrNow(events[i], now), f.placeholders(events[i], time.Time{}))
+		if err != nil {
+			return err
+		}
+		b, ok := f.buckets[key]
+		if ok {
+			f.lru.MoveToFront(b.element)
+		} else if b, err = f.open(key, events[i], now); err != nil {
+			return err
+		}
+
+		n, err := b.out.Write(record)
+		b.bytes += int64(n)
+		if err != nil {
+			return err
+		}
+		b.events++
+		b.lastWrite = now
+		b.dirty = true
+
+		if (f.rollEvents > 0 && b.events >= f.rollEvents) ||
+			(f.rollBytes > 0 && b.bytes >= f.rollBytes) {
+			if err := f.roll(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// open starts a new file for a bucket in the incomplete directory, under a
+// name that isn't in use in either directory.  If that's more files than
+// max_open_files the least recently written one is rolled.
+func (f *FileSink) open(key string, e Event, now time.Time) (*fileBucket, error) {
+	if f.maxOpen > 0 && len(f.buckets) >= f.maxOpen {
+		if err := f.roll(f.lru.Back().Value.(*fileBucket)); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		f.seq++
+		name, err := expandTemplate(f.fileName, eventTimeOrNow(e, now), f.placeholders(e, now))
+		if err != nil {
+			return nil, err
+		}
+		if attempt > 0 && !strings.Contains(f.fileName, "%{seq}") {
+			name = numberedName(name, attempt)
+		}
+		name += compressionExtensions[f.compression]
+
+		if fileExists(filepath.Join(f.completePath, name)) {
+			continue
+		}
+		incName := filepath.Join(f.incompletePath, name+".inc")
+		if err := os.MkdirAll(filepath.Dir(incName), 0755); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(incName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		b := &fileBucket{
+			key:       key,
+			name:      name,
+			file:      file,
+			out:       file,
+			nextRoll:  now.Add(f.rollInterval),
+			lastWrite: now,
+		}
+		if f.compression != COMPRESSION_NONE {
+			if b.out, err = newCompressor(f.compression, file); err != nil {
+				file.Close()
+				os.Remove(incName)
+				return nil, err
+			}
+		}
+		b.element = f.lru.PushFront(b)
+		f.buckets[key] = b
+
+		if begin := f.serializer.Begin(); len(begin) > 0 {
+			n, err := b.out.Write(begin)
+			b.bytes += int64(n)
+			return b, err
+		}
+		return b, nil
+	}
+}
+
+// sync flushes and syncs every file written to since the last sync
+func (f *FileSink) sync() error {
+	for _, b := range f.buckets {
+		if !b.dirty {
+			continue
+		}
+		if flusher, ok := b.out.(interface{ Flush() error }); ok {
+			if err := flusher.Flush(); err != nil {
+				return err
+			}
+		}
+		if f.fsync {
+			if err := b.file.Sync(); err != nil {
+				return err
+			}
+		}
+		b.dirty = false
+	}
+	return nil
+}
+
+// placeholders looks up the %{placeholder} values for an event.  The ones
+// that differ for every file are blank without the time the file was
+// opened, which leaves the name of the event's bucket.
+func (f *FileSink) placeholders(e Event, opened time.Time) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		switch name {
+		case "host":
+			return f.hostname, true
+		case "sink":
+			return f.sink, true
+		case "seq", "unix", "unix_ms":
+			if opened.IsZero() {
+				return "", true
+			}
+			switch name {
+			case "seq":
+				return strconv.FormatUint(f.seq, 10), true
+			case "unix":
+				return FormatTimestamp(opened, TIMESTAMP_UNIX), true
+			}
+			return FormatTimestamp(opened, TIMESTAMP_UNIX_MS), true
+		}
+		return pathSafe(e.Headers[name]), true
+	}
+}
+
+// rollDue rolls every file that's past roll_interval or idle_timeout,
+// returning when the next one will be due
+func (f *FileSink) rollDue(now time.Time) time.Time {
+	var next time.Time
+	for _, b := range f.buckets {
+		due := b.lastWrite.Add(f.idleTimeout)
+		if f.rollInterval > 0 && b.nextRoll.Before(due) {
+			due = b.nextRoll
+		}
+		if due.After(now) {
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+			continue
+		}
+		if err := f.roll(b); err != nil {
+			log.Printf("%s: roll: %s", f.component, err)
+		}
+	}
+	return next
+}
+
+// rollAll rolls every open file
+func (f *FileSink) rollAll() error {
+	var err error
+	for _, b := range f.buckets {
+		if rollErr := f.roll(b); rollErr != nil {
+			err = rollErr
+		}
+	}
+	return err
+}
+
+// roll finishes a bucket's file and moves it to the complete directory.
+// The file is synced before it's renamed, and the rename synced after, so a
+// file in the complete directory is always whole, even after a crash.
+func (f *FileSink) roll(b *fileBucket) error {
+	delete(f.buckets, b.key)
+	f.lru.Remove(b.element)
+
+	// closing the compressor writes its trailer
+	if closer, ok := b.out.(io.Closer); ok && b.out != b.file {
+		if err := closer.Close(); err != nil {
+			b.file.Close()
+			return err
+		}
+	}
+	if b.events == 0 && f.emptyFiles != EMPTY_FILES_KEEP {
+		b.file.Close()
+		if f.emptyFiles == EMPTY_FILES_QUARANTINE {
+			return f.quarantine(b.file.Name(), b.name)
+		}
+		return os.Remove(b.file.Name())
+	}
+	if err := b.file.Sync(); err != nil {
+		b.file.Close()
+		return err
+	}
+	if err := b.file.Close(); err != nil {
+		return err
+	}
+
+	return f.complete(b.file.Name(), b.name)
+}
+
+// complete moves a finished file to the complete directory
+func (f *FileSink) complete(incName string, name string) error {
+	completeName := uniqueName(filepath.Join(f.completePath, name))
+	if err := os.MkdirAll(filepath.Dir(completeName), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(incName, completeName); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(completeName))
+}
+
+// quarantine moves a file that can't be completed to the quarantine
+// directory, leaving it named as an incomplete file
+func (f *FileSink) quarantine(incName string, name string) error {
+	quarantineName := uniqueName(filepath.Join(f.quarantinePath, name+".inc"))
+	if err := os.MkdirAll(filepath.Dir(quarantineName), 0755); err != nil {
+		return err
+	}
+	return os.Rename(incName, quarantineName)
+}
+
+func (f *FileSink) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
+
+// eventTimeOrNow is the time used to fill in templates such as an event's
+// file name or index
+func eventTimeOrNow(e Event, now time.Time) time.Time {
+	if t, ok := EventTime(e); ok {
+		return t.UTC()
+	}
+	if !e.IngestTime.IsZero() {
+		return e.IngestTime.UTC()
+	}
+	return now.UTC()
+}
+
+// pathSafe keeps header values from adding directories to file names
+func pathSafe(value string) string {
+	value = strings.NewReplacer("/", "_", "\\", "_").Replace(value)
+	if value == "" || value == "." || value == ".." {
+		return "_"
+	}
+	return value
+}
+
+// numberedName adds -n before a file name's extension
+func numberedName(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// uniqueName numbers a file name if it's already taken
+func uniqueName(name string) string {
+	unique := name
+	for n := 1; fileExists(unique); n++ {
+		unique = numberedName(name, n)
+	}
+	return unique
+}
+
+// syncDir makes changes to a directory's entries, such as renames, durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func fileExists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+var errBadTemplate = errors.New("unknown placeholder")
+
+// expandTemplate fills in a file name template's time fields from t and its
+// %{name} placeholders with lookup
+func expandTemplate(template string, t time.Time, lookup func(string) (string, bool)) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' || i == len(template)-1 {
+			out.WriteByte(template[i])
+			continue
+		}
+		i++
+		switch template[i] {
+		case 'Y':
+			out.WriteString(t.Format("2006"))
+		case 'm':
+			out.WriteString(t.Format("01"))
+		case 'd':
+			out.WriteString(t.Format("02"))
+		case 'H':
+			out.WriteString(t.Format("15"))
+		case 'M':
+			out.WriteString(t.Format("04"))
+		case 'S':
+			out.WriteString(t.Format("05"))
+		case '%':
+			out.WriteByte('%')
+		case '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return "", errBadTemplate
+			}
+			value, ok := lookup(template[i+1 : i+end])
+			if !ok {
+				return "", fmt.Errorf("%s %%{%s}", errBadTemplate, template[i+1:i+end])
+			}
+			out.WriteString(value)
+			i += end
+		default:
+			return "", fmt.Errorf("%s %%%c", errBadTemplate, template[i])
+		}
+	}
+	return out.String(), nil
+}
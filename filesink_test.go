@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func initFileSinkTest(t *testing.T, extra ComponentSettings) (string, *FileSink) {
+	dir, err := ioutil.TempDir("", "collectord_filesink")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	c := ComponentSettings{
+		"name":       "files",
+		"incomplete": path.Join(dir, "incomplete"),
+		"complete":   path.Join(dir, "complete"),
+		"serializer": "body",
+	}
+	for k, v := range extra {
+		c[k] = v
+	}
+	os.Mkdir(c["incomplete"], 0755)
+	os.Mkdir(c["complete"], 0755)
+	return dir, NewFileSink(c).(*FileSink)
+}
+
+// readFiles returns the contents of each file under dir by relative name
+func readFiles(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := walkFiles(dir, func(name string) {
+		contents, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", name, err)
+		}
+		files[name] = string(contents)
+	})
+	if err != nil {
+		t.Fatalf("Failed to list %s: %s", dir, err)
+	}
+	return files
+}
+
+func walkFiles(dir string, found func(string)) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			err = walkFiles(path.Join(dir, info.Name()), func(name string) {
+				found(path.Join(info.Name(), name))
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			found(info.Name())
+		}
+	}
+	return nil
+}
+
+func sortedContents(files map[string]string) []string {
+	contents := make([]string, 0, len(files))
+	for _, c := range files {
+		contents = append(contents, c)
+	}
+	sort.Strings(contents)
+	return contents
+}
+
+func writeEvents(t *testing.T, f *FileSink, events []Event) {
+	records, ok := f.serialize(events)
+	if !ok {
+		t.Fatal("Failed to serialize events")
+	}
+	if err := f.write(events, records); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+}
+
+func TestFileSinkRollEvents(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"roll_events": "2"})
+	defer os.RemoveAll(dir)
+
+	writeEvents(t, f, makeDummyEvents(5))
+
+	complete := readFiles(t, path.Join(dir, "complete"))
+	got := sortedContents(complete)
+	if len(got) != 2 || got[0] != "Event 0\nEvent 1\n" || got[1] != "Event 2\nEvent 3\n" {
+		t.Errorf("Expected two complete files of two events, got %q", got)
+	}
+	incomplete := readFiles(t, path.Join(dir, "incomplete"))
+	if len(incomplete) != 1 || sortedContents(incomplete)[0] != "Event 4\n" {
+		t.Errorf("Expected the last event in an incomplete file, got %q", incomplete)
+	}
+	for name := range incomplete {
+		if !strings.HasSuffix(name, ".log.inc") {
+			t.Errorf("Expected an .inc file, got %s", name)
+		}
+	}
+
+	if err := f.rollAll(); err != nil {
+		t.Fatalf("Failed to roll: %s", err)
+	}
+	if n := len(readFiles(t, path.Join(dir, "complete"))); n != 3 {
+		t.Errorf("Expected 3 complete files after rolling, got %d", n)
+	}
+}
+
+func TestFileSinkRollBytes(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"roll_bytes": "10"})
+	defer os.RemoveAll(dir)
+
+	// each body is 8 bytes with its newline, so files roll after two
+	writeEvents(t, f, makeDummyEvents(4))
+
+	got := sortedContents(readFiles(t, path.Join(dir, "complete")))
+	if len(got) != 2 || got[0] != "Event 0\nEvent 1\n" {
+		t.Errorf("Expected two files of two events, got %q", got)
+	}
+}
+
+func TestFileSinkRollInterval(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"roll_interval": "100ms"})
+	defer os.RemoveAll(dir)
+
+	channel := NewMemoryChannel(ComponentSettings{})
+	f.SetChannel(channel)
+	channel.AddEvents(makeDummyEvents(2))
+	if err := f.Start(); err != nil {
+		t.Fatalf("Failed to start sink: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := sortedContents(readFiles(t, path.Join(dir, "complete"))); len(got) == 1 {
+			if got[0] != "Event 0\nEvent 1\n" {
+				t.Errorf("Wrong file contents: %q", got[0])
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the file to roll")
+}
+
+func TestFileSinkNoCollisions(t *testing.T) {
+	// the legacy name is the same for every file opened in the same second
+	dir, f := initFileSinkTest(t, ComponentSettings{"file_name": "%{unix}.txt", "roll_events": "1"})
+	defer os.RemoveAll(dir)
+
+	writeEvents(t, f, makeDummyEvents(3))
+
+	complete := readFiles(t, path.Join(dir, "complete"))
+	if len(complete) != 3 {
+		t.Fatalf("Expected 3 distinct files, got %q", complete)
+	}
+	for name := range complete {
+		if !strings.HasSuffix(name, ".txt") {
+			t.Errorf("Expected the extension to be kept, got %s", name)
+		}
+	}
+}
+
+func TestFileSinkTemplate(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"file_name": "%{sink}/%Y/%{seq}.log", "roll_events": "1"})
+	defer os.RemoveAll(dir)
+
+	writeEvents(t, f, makeDummyEvents(2))
+
+	year := time.Now().UTC().Format("2006")
+	complete := readFiles(t, path.Join(dir, "complete"))
+	for _, name := range []string{"files/" + year + "/1.log", "files/" + year + "/2.log"} {
+		if _, ok := complete[name]; !ok {
+			t.Errorf("Expected %s, got %q", name, complete)
+		}
+	}
+}
+
+func TestFileSinkBuckets(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"file_name": "%{Hostname}/%Y/%m/%d/%H/%{seq}.log"})
+	defer os.RemoveAll(dir)
+
+	events := makeDummyEvents(4)
+	for i, ts := range []string{"1792332000", "1792335600", "1792332001", "1792332002"} {
+		events[i].Headers["Timestamp"] = ts
+	}
+	events[0].Headers["Hostname"] = "web1"
+	events[1].Headers["Hostname"] = "web1"
+	events[2].Headers["Hostname"] = "web2"
+	events[3].Headers["Hostname"] = "../etc"
+	writeEvents(t, f, events)
+
+	if len(f.buckets) != 4 {
+		t.Errorf("Expected 4 open files, got %d", len(f.buckets))
+	}
+	f.rollAll()
+
+	complete := readFiles(t, path.Join(dir, "complete"))
+	expected := map[string]string{
+		"web1/2026/10/18/14/1.log":   "Event 0\n",
+		"web1/2026/10/18/15/2.log":   "Event 1\n",
+		"web2/2026/10/18/14/3.log":   "Event 2\n",
+		".._etc/2026/10/18/14/4.log": "Event 3\n",
+	}
+	for name, contents := range expected {
+		if complete[name] != contents {
+			t.Errorf("Expected %q in %s, got %q", contents, name, complete)
+		}
+	}
+}
+
+func TestFileSinkDefaultTemplate(t *testing.T) {
+	dir, f := initFileSinkTest(t, nil)
+	defer os.RemoveAll(dir)
+
+	// events seconds and minutes apart share the hour's file
+	events := makeDummyEvents(4)
+	for i, ts := range []string{"2026-10-18T14:00:01Z", "2026-10-18T14:00:02Z", "2026-10-18T14:59:59Z", "2026-10-18T15:00:00Z"} {
+		events[i].Headers["Timestamp"] = ts
+	}
+	writeEvents(t, f, events)
+	if len(f.buckets) != 2 {
+		t.Errorf("Expected a file open per hour, got %d", len(f.buckets))
+	}
+
+	if err := f.rollAll(); err != nil {
+		t.Fatalf("Failed to roll: %s", err)
+	}
+	complete := readFiles(t, path.Join(dir, "complete"))
+	expected := map[string]string{
+		f.hostname + "-2026101814-1.log": "Event 0\nEvent 1\nEvent 2\n",
+		f.hostname + "-2026101815-2.log": "Event 3\n",
+	}
+	if !reflect.DeepEqual(complete, expected) {
+		t.Errorf("Expected %v, got %v", expected, complete)
+	}
+}
+
+func TestFileSinkMaxOpenFiles(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"file_name": "%{num}-%{seq}.log", "max_open_files": "2"})
+	defer os.RemoveAll(dir)
+
+	events := makeDummyEvents(3)
+	writeEvents(t, f, events[:2])
+	// writing to 0 again makes 1 the least recently written
+	writeEvents(t, f, events[:1])
+	writeEvents(t, f, events[2:])
+
+	complete := readFiles(t, path.Join(dir, "complete"))
+	if len(complete) != 1 || complete["1-2.log"] != "Event 1\n" {
+		t.Errorf("Expected the least recently written file to be rolled, got %q", complete)
+	}
+	if len(f.buckets) != 2 {
+		t.Errorf("Expected 2 open files, got %d", len(f.buckets))
+	}
+}
+
+func TestFileSinkIdleTimeout(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"file_name": "%{num}.log", "idle_timeout": "1m", "roll_interval": "1h"})
+	defer os.RemoveAll(dir)
+
+	events := makeDummyEvents(2)
+	writeEvents(t, f, events)
+	now := time.Now()
+	f.buckets["1.log"].lastWrite = now.Add(-2 * time.Minute)
+
+	next := f.rollDue(now)
+	complete := readFiles(t, path.Join(dir, "complete"))
+	if len(complete) != 1 || complete["1.log"] != "Event 1\n" {
+		t.Errorf("Expected the idle file to be rolled, got %q", complete)
+	}
+	if next.Before(now) || next.After(now.Add(time.Minute)) {
+		t.Errorf("Expected the next roll within the idle timeout, got %s", next)
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	now := time.Date(2026, 10, 18, 14, 5, 9, 0, time.UTC)
+	lookup := func(name string) (string, bool) {
+		return "web1", name == "host"
+	}
+
+	got, err := expandTemplate("%{host}/%Y/%m/%d/%H%M%S-100%%", now, lookup)
+	if err != nil || got != "web1/2026/10/18/140509-100%" {
+		t.Errorf("Wrong expansion %q: %v", got, err)
+	}
+	for _, bad := range []string{"%{nope}", "%{host", "%Q"} {
+		if _, err := expandTemplate(bad, now, lookup); err == nil {
+			t.Errorf("Expected an error expanding %s", bad)
+		}
+	}
+}
+
+func TestFileSinkCompression(t *testing.T) {
+	decompress := map[string]func([]byte) ([]byte, error){
+		"gzip": func(data []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.ReadAll(r)
+		},
+		"zstd": func(data []byte) ([]byte, error) {
+			r, err := zstd.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return ioutil.ReadAll(r)
+		},
+	}
+
+	for compression, decompress := range decompress {
+		dir, f := initFileSinkTest(t, ComponentSettings{"compression": compression, "roll_events": "2"})
+		writeEvents(t, f, makeDummyEvents(4))
+
+		complete := readFiles(t, path.Join(dir, "complete"))
+		if len(complete) != 2 {
+			t.Errorf("%s: expected 2 files, got %d", compression, len(complete))
+		}
+		got := make([]string, 0)
+		for name, contents := range complete {
+			if !strings.HasSuffix(name, ".log"+compressionExtensions[compression]) {
+				t.Errorf("%s: wrong extension on %s", compression, name)
+			}
+			// every file must be a complete stream on its own
+			plain, err := decompress([]byte(contents))
+			if err != nil {
+				t.Errorf("%s: failed to decompress %s: %s", compression, name, err)
+			}
+			got = append(got, string(plain))
+		}
+		sort.Strings(got)
+		if len(got) != 2 || got[0] != "Event 0\nEvent 1\n" || got[1] != "Event 2\nEvent 3\n" {
+			t.Errorf("%s: wrong contents %q", compression, got)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileSinkRecovery(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"compression": "gzip", "serializer": "tsv", "columns": "body"})
+	defer os.RemoveAll(dir)
+	incomplete := path.Join(dir, "incomplete")
+
+	// a crash part way through a record
+	ioutil.WriteFile(path.Join(incomplete, "a.log.inc"), []byte("Event 0\nEvent 1\nEv"), 0644)
+	ioutil.WriteFile(path.Join(incomplete, "empty.log.inc"), nil, 0644)
+	// a compressed file synced after each batch has no trailer
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("Event 2\n"))
+	w.Flush()
+	w.Write([]byte("Eve"))
+	os.Mkdir(path.Join(incomplete, "sub"), 0755)
+	ioutil.WriteFile(path.Join(incomplete, "sub", "b.log.gz.inc"), gz.Bytes(), 0644)
+	ioutil.WriteFile(path.Join(incomplete, "c.log.inc.tmp"), []byte("junk"), 0644)
+	// already taken in complete, so a.log is renamed
+	ioutil.WriteFile(path.Join(dir, "complete", "a.log"), []byte("old\n"), 0644)
+
+	if err := f.recoverFiles(); err != nil {
+		t.Fatalf("Failed to recover: %s", err)
+	}
+
+	if left := readFiles(t, incomplete); len(left) != 0 {
+		t.Errorf("Expected the incomplete directory to be empty, got %q", left)
+	}
+	complete := readFiles(t, path.Join(dir, "complete"))
+	if complete["a-1.log"] != "Event 0\nEvent 1\n" || complete["a.log"] != "old\n" {
+		t.Errorf("Expected the partial record to be cut off, got %q", complete)
+	}
+	if _, ok := complete["empty.log"]; ok {
+		t.Error("Expected the empty file to be deleted")
+	}
+	r, err := gzip.NewReader(strings.NewReader(complete["sub/b.log.gz"]))
+	if err != nil {
+		t.Fatalf("Expected a valid gzip file: %s", err)
+	}
+	if plain, err := ioutil.ReadAll(r); err != nil || string(plain) != "Event 2\n" {
+		t.Errorf("Wrong recovered gzip contents %q: %v", plain, err)
+	}
+}
+
+func TestFileSinkRecoveryQuarantine(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"serializer": "avro", "empty_files": "quarantine"})
+	defer os.RemoveAll(dir)
+	incomplete := path.Join(dir, "incomplete")
+
+	ioutil.WriteFile(path.Join(incomplete, "a.avro.inc"), []byte("Obj\x01 and then some"), 0644)
+	ioutil.WriteFile(path.Join(incomplete, "empty.avro.inc"), nil, 0644)
+	if err := f.recoverFiles(); err != nil {
+		t.Fatalf("Failed to recover: %s", err)
+	}
+
+	quarantined := readFiles(t, path.Join(incomplete, "quarantine"))
+	if len(quarantined) != 2 || quarantined["a.avro.inc"] == "" {
+		t.Errorf("Expected both files to be quarantined, got %q", quarantined)
+	}
+	if complete := readFiles(t, path.Join(dir, "complete")); len(complete) != 0 {
+		t.Errorf("Expected nothing to be completed, got %q", complete)
+	}
+}
+
+func TestFileSinkRecoveryBodyQuarantine(t *testing.T) {
+	dir, f := initFileSinkTest(t, nil)
+	defer os.RemoveAll(dir)
+	incomplete := path.Join(dir, "incomplete")
+
+	// the body may have had more lines, so there's no telling where it ends
+	ioutil.WriteFile(path.Join(incomplete, "a.log.inc"), []byte("first\nsecond line of the\nsame bo"), 0644)
+	if err := f.recoverFiles(); err != nil {
+		t.Fatalf("Failed to recover: %s", err)
+	}
+	if quarantined := readFiles(t, path.Join(incomplete, "quarantine")); len(quarantined) != 1 {
+		t.Errorf("Expected the body file to be quarantined, got %q", quarantined)
+	}
+}
+
+func TestFileSinkRecoveryKeepEmpty(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"empty_files": "keep", "serializer": "json"})
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "incomplete", "empty.log.inc"), []byte("no newline"), 0644)
+	if err := f.recoverFiles(); err != nil {
+		t.Fatalf("Failed to recover: %s", err)
+	}
+	if contents, ok := readFiles(t, path.Join(dir, "complete"))["empty.log"]; !ok || contents != "no newline" {
+		t.Errorf("Expected the empty file to be kept, got %q", contents)
+	}
+}
+
+func TestFileSinkDurableStop(t *testing.T) {
+	dir, f := initFileSinkTest(t, ComponentSettings{"compression": "gzip"})
+	defer os.RemoveAll(dir)
+
+	channel := NewMemoryChannel(ComponentSettings{})
+	f.SetChannel(channel)
+	channel.AddEvents(makeDummyEvents(2))
+	if err := f.Start(); err != nil {
+		t.Fatalf("Failed to start sink: %s", err)
+	}
+
+	// once confirmed the events must be readable from the incomplete file
+	deadline := time.Now().Add(5 * time.Second)
+	for channel.WaitForEvents(context.Background(), time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for events to be confirmed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for name, contents := range readFiles(t, path.Join(dir, "incomplete")) {
+		plain, _ := decompressPartial("gzip", []byte(contents))
+		if string(plain) != "Event 0\nEvent 1\n" {
+			t.Errorf("Expected confirmed events to be flushed to %s, got %q", name, plain)
+		}
+	}
+
+	if err := f.Stop(); err != nil {
+		t.Fatalf("Failed to stop: %s", err)
+	}
+	if n := len(readFiles(t, path.Join(dir, "complete"))); n != 1 {
+		t.Errorf("Expected the open file to be completed on stop, got %d files", n)
+	}
+}
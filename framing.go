@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Split functions for bufio.Scanner used by the stream based sources.
+
+var ErrBadFrameLength = errors.New("invalid frame length prefix")
+
+// scanNewlineFrames splits newline terminated records, dropping any trailing
+// carriage return.  A final unterminated record is returned at EOF.
+func scanNewlineFrames(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, bytes.TrimSuffix(data[:i], []byte{'\r'}), nil
+	}
+	if atEOF {
+		return len(data), bytes.TrimSuffix(data, []byte{'\r'}), nil
+	}
+	return 0, nil, nil
+}
+
+// scanSyslogFrames splits syslog messages sent over a stream transport as
+// described in RFC 6587.  Frames starting with a digit use octet counting
+// ("MSG-LEN SP SYSLOG-MSG"), anything else is treated as newline terminated.
+// The framing is detected per message so senders may mix the two.
+func scanSyslogFrames(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if data[0] < '0' || data[0] > '9' {
+		return scanNewlineFrames(data, atEOF)
+	}
+
+	space := bytes.IndexByte(data, ' ')
+	if space < 0 {
+		if atEOF || len(data) > 10 {
+			return 0, nil, ErrBadFrameLength
+		}
+		return 0, nil, nil
+	}
+	length, err := strconv.Atoi(string(data[:space]))
+	if err != nil || length < 0 {
+		return 0, nil, ErrBadFrameLength
+	}
+
+	end := space + 1 + length
+	if len(data) < end {
+		if atEOF {
+			return 0, nil, ErrBadFrameLength
+		}
+		return 0, nil, nil
+	}
+	return end, data[space+1 : end], nil
+}
+
+// delimiterFrames returns a split function for records separated by an
+// arbitrary delimiter.  A final unterminated record is returned at EOF.
+func delimiterFrames(delim []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, delim); i >= 0 {
+			return i + len(delim), data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// scanLengthPrefixedFrames splits records preceded by their length as a 4
+// byte big endian integer.
+func scanLengthPrefixedFrames(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if len(data) < 4 {
+		if atEOF {
+			return 0, nil, ErrBadFrameLength
+		}
+		return 0, nil, nil
+	}
+
+	end := 4 + int(binary.BigEndian.Uint32(data))
+	if end < 4 {
+		return 0, nil, ErrBadFrameLength
+	}
+	if len(data) < end {
+		if atEOF {
+			return 0, nil, ErrBadFrameLength
+		}
+		return 0, nil, nil
+	}
+	return end, data[4:end], nil
+}
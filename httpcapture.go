@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestCapture decides which parts of a request become event headers.
+// Each capture setting is a comma separated list of names, optionally
+// renamed with a colon ("X-Request-Id:RequestId").  Path segments are picked
+// by their zero based index in the request path ("1:Section").
+type requestCapture struct {
+	headers         []captureField
+	cookies         []captureField
+	query           []captureField
+	pathSegments    []captureField
+	trustedProxies  []*net.IPNet
+	timestampFormat string
+}
+
+type captureField struct {
+	name   string
+	header string
+	index  int
+}
+
+func newRequestCapture(config ComponentSettings) *requestCapture {
+	headers, ok := config["capture_headers"]
+	if !ok {
+		headers = "Referer:Referrer, User-Agent:UserAgent"
+	}
+
+	c := &requestCapture{
+		headers: parseCaptureFields(headers),
+		cookies: parseCaptureFields(config["capture_cookies"]),
+		query:   parseCaptureFields(config["capture_query"]),
+
+		timestampFormat: stampFormatSetting("httpsource", config, TIMESTAMP_UNIX_MS),
+	}
+
+	for _, field := range parseCaptureFields(config["capture_path"]) {
+		index, err := strconv.Atoi(field.name)
+		if err != nil || index < 0 {
+			log.Fatalf("httpsource: invalid capture_path segment %s", field.name)
+		}
+		if field.header == field.name {
+			log.Fatalf("httpsource: capture_path segment %d needs a header name", index)
+		}
+		field.index = index
+		c.pathSegments = append(c.pathSegments, field)
+	}
+
+	for _, cidr := range splitList(config["trusted_proxies"]) {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("httpsource: invalid trusted_proxies entry %s: %s", cidr, err)
+		}
+		c.trustedProxies = append(c.trustedProxies, network)
+	}
+
+	return c
+}
+
+func parseCaptureFields(setting string) []captureField {
+	fields := make([]captureField, 0)
+	for _, item := range splitList(setting) {
+		field := captureField{name: item, header: item}
+		if i := strings.Index(item, ":"); i >= 0 {
+			field.name = strings.TrimSpace(item[:i])
+			field.header = strings.TrimSpace(item[i+1:])
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// newEvent creates an event with the configured request headers filled in.
+// Timestamp, RemoteAddr and the captured request headers are always set,
+// while cookies, query parameters and path segments are only set if present.
+func (c *requestCapture) newEvent(r *http.Request, ts time.Time) Event {
+	e := NewEvent()
+	SetEventTime(&e, ts, c.timestampFormat)
+	e.Headers["RemoteAddr"] = c.clientAddr(r)
+
+	for _, field := range c.headers {
+		e.Headers[field.header] = r.Header.Get(field.name)
+	}
+	for _, field := range c.cookies {
+		if cookie, err := r.Cookie(field.name); err == nil {
+			e.Headers[field.header] = cookie.Value
+		}
+	}
+	if len(c.query) > 0 {
+		query := r.URL.Query()
+		for _, field := range c.query {
+			if values, ok := query[field.name]; ok && len(values) > 0 {
+				e.Headers[field.header] = values[0]
+			}
+		}
+	}
+	if len(c.pathSegments) > 0 {
+		segments := splitPath(r.URL.Path)
+		for _, field := range c.pathSegments {
+			if field.index < len(segments) {
+				e.Headers[field.header] = segments[field.index]
+			}
+		}
+	}
+	return e
+}
+
+func splitPath(path string) []string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// clientAddr is the request's remote address, unless that is a trusted
+// proxy.  Then the client is the last address in X-Forwarded-For that isn't
+// itself a trusted proxy, or failing that X-Real-IP.
+func (c *requestCapture) clientAddr(r *http.Request) string {
+	if len(c.trustedProxies) == 0 {
+		return r.RemoteAddr
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !c.trusted(host) {
+		return r.RemoteAddr
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop != "" && (i == 0 || !c.trusted(hop)) {
+				return hop
+			}
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return r.RemoteAddr
+}
+
+func (c *requestCapture) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
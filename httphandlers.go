@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+func init() {
+	RegisterHttpHandler("default", func(ComponentSettings) HttpHandler { return &defaultHttpHandler{} })
+	RegisterHttpHandler("json", func(ComponentSettings) HttpHandler { return &jsonHttpHandler{} })
+	RegisterHttpHandler("ndjson", func(ComponentSettings) HttpHandler { return &ndjsonHttpHandler{} })
+}
+
+// HttpHandler turns a request received by an HttpSource into events.  body
+// is the request body with any content encoding already removed, and base
+// carries the headers captured from the request, which every event made
+// from it should start with.
+type HttpHandler interface {
+	Events(r *http.Request, body []byte, base Event) ([]Event, error)
+}
+
+// HttpError lets handlers pick the status code a failed request gets,
+// other errors are treated as a bad request.  Allow lists the supported
+// methods for a 405 response.
+type HttpError struct {
+	Code    int
+	Message string
+	Allow   string
+}
+
+func (e *HttpError) Error() string {
+	return e.Message
+}
+
+// Global http handler registry
+
+var registeredHttpHandlers map[string]func(ComponentSettings) HttpHandler = make(map[string]func(ComponentSettings) HttpHandler)
+
+func RegisterHttpHandler(name string, constructor func(ComponentSettings) HttpHandler) {
+	registeredHttpHandlers[name] = constructor
+}
+
+func NewHttpHandler(name string, config ComponentSettings) HttpHandler {
+	constructor, ok := registeredHttpHandlers[name]
+	if !ok {
+		log.Fatalf("No http handler registered for name [%s]", name)
+	}
+	return constructor(config)
+}
+
+// copyHeaders returns a new event with the same headers as base
+func copyHeaders(base Event) Event {
+	e := NewEvent()
+	for k, v := range base.Headers {
+		e.Headers[k] = v
+	}
+	return e
+}
+
+func methodNotAllowed(r *http.Request, allow string) error {
+	return &HttpError{
+		Code:    http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("unsupported method %s", r.Method),
+		Allow:   allow,
+	}
+}
+
+func requirePost(r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed(r, "POST")
+	}
+	return nil
+}
+
+// defaultHttpHandler makes a single event from a POST body or the query
+// string of a GET.
+type defaultHttpHandler struct{}
+
+func (d *defaultHttpHandler) Events(r *http.Request, body []byte, base Event) ([]Event, error) {
+	e := base
+	switch r.Method {
+	case "GET":
+		e.Body = []byte(r.URL.RawQuery)
+	case "POST":
+		e.Body = body
+	default:
+		return nil, methodNotAllowed(r, "GET, POST")
+	}
+	return []Event{e}, nil
+}
+
+// jsonHttpHandler accepts a POSTed array of {"headers": {...}, "body": "..."}
+// objects, one per event.  Headers captured from the request are filled in
+// where the object doesn't set them.
+type jsonHttpHandler struct{}
+
+type jsonHttpEvent struct {
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+func (j *jsonHttpHandler) Events(r *http.Request, body []byte, base Event) ([]Event, error) {
+	if err := requirePost(r); err != nil {
+		return nil, err
+	}
+
+	var batch []jsonHttpEvent
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("invalid json batch: %s", err)
+	}
+
+	events := make([]Event, 0, len(batch))
+	for _, item := range batch {
+		e := copyHeaders(base)
+		for k, v := range item.Headers {
+			e.Headers[k] = v
+		}
+		e.Body = []byte(item.Body)
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// ndjsonHttpHandler makes an event from each non-empty line of a POST body,
+// with the JSON document on that line as the event body.  A batch with any
+// invalid line is rejected as a whole.
+type ndjsonHttpHandler struct{}
+
+func (n *ndjsonHttpHandler) Events(r *http.Request, body []byte, base Event) ([]Event, error) {
+	if err := requirePost(r); err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0)
+	for i, line := range bytes.Split(body, []byte{'\n'}) {
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return nil, fmt.Errorf("invalid json on line %d", i+1)
+		}
+		e := copyHeaders(base)
+		e.Body = line
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterSink("http", NewHttpSink)
+}
+
+// HttpSink sends events to a URL, one event per request or batch_size
+// events at a time.  The batch setting picks the request body:
+//
+//	none    each event on its own, formatted by the serializer (body by
+//	        default)
+//	json    an array of {"headers": {...}, "body": "..."} objects, as read by
+//	        the http source's json handler
+//	ndjson  the serializer's records (json by default) one after another
+//
+// Request headers are set with header.<Name> settings.  Requests that time
+// out, fail to connect or get a 408, 429 or 5xx response are retried, waiting
+// as long as a Retry-After header asks.  Any other response outside 2xx
+// means the events will never be accepted, so they're given up on straight
+// away and moved to the dead letter channel if there is one.
+type HttpSink struct {
+	*deliveries
+	channel    Channel
+	url        string
+	method     string
+	headers    http.Header
+	batch      string
+	batchSize  int
+	serializer Serializer
+	gzip       bool
+	client     *http.Client
+	backoff    retryBackoff
+}
+
+// httpSinkError is a response the sink didn't want
+type httpSinkError struct {
+	Status     string
+	StatusCode int
+	Retryable  bool
+	RetryAfter time.Duration
+}
+
+func (e *httpSinkError) Error() string {
+	return fmt.Sprintf("server responded %s", e.Status)
+}
+
+// newHttpSinkError describes an unsuccessful response.  Only timeouts, rate
+// limiting and server errors are worth retrying.
+func newHttpSinkError(resp *http.Response) *httpSinkError {
+	return &httpSinkError{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Retryable: resp.StatusCode >= 500 ||
+			resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode == http.StatusRequestTimeout,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func NewHttpSink(config ComponentSettings) Sink {
+	url, ok := config["url"]
+	if !ok {
+		log.Fatal("Must configure url for http sink")
+	}
+
+	h := &HttpSink{
+		deliveries: newDeliveries("httpsink", config),
+		url:        url,
+		method:     "POST",
+		headers:    make(http.Header),
+		batch:      "none",
+		batchSize:  HTTP_SINK_BATCH,
+		gzip:       config["gzip"] == "true",
+		client:     &http.Client{Timeout: HTTP_SINK_TIMEOUT},
+	}
+
+	if method, ok := config["method"]; ok {
+		h.method = strings.ToUpper(method)
+	}
+
+	serializer := "body"
+	contentType := "application/octet-stream"
+	if batch, ok := config["batch"]; ok {
+		h.batch = batch
+	}
+	switch h.batch {
+	case "none":
+	case "json":
+		contentType = "application/json"
+	case "ndjson":
+		serializer = "json"
+		contentType = "application/x-ndjson"
+	default:
+		log.Fatalf("httpsink: invalid batch %s", h.batch)
+	}
+	if s, ok := config["serializer"]; ok {
+		serializer = s
+	}
+	h.serializer = NewSerializer(serializer, config)
+	h.headers.Set("Content-Type", contentType)
+
+	for name, value := range config {
+		if strings.HasPrefix(name, "header.") {
+			h.headers.Set(strings.TrimPrefix(name, "header."), value)
+		}
+	}
+
+	if size, ok := config["batch_size"]; ok {
+		var err error
+		if h.batchSize, err = strconv.Atoi(size); err != nil || h.batchSize <= 0 {
+			log.Fatalf("httpsink: invalid batch_size %s", size)
+		}
+	}
+	if timeout, ok := config["timeout"]; ok {
+		var err error
+		if h.client.Timeout, err = time.ParseDuration(timeout); err != nil || h.client.Timeout < 0 {
+			log.Fatalf("httpsink: invalid timeout %s", timeout)
+		}
+	}
+
+	return h
+}
+
+func (h *HttpSink) SetChannel(channel Channel) error {
+	h.channel = channel
+	return nil
+}
+
+func (h *HttpSink) Start() error {
+	if h.channel == nil {
+		return errors.New("httpsink: no channel set")
+	}
+	go h.loopForever()
+	return nil
+}
+
+func (h *HttpSink) loopForever() {
+	for {
+		if !h.channel.WaitForEvents(context.Background(), SINK_IDLE_TIMEOUT) {
+			continue
+		}
+		_, events, err := h.channel.GetOldest(h.batchSize)
+		if err != nil {
+			log.Printf("httpsink: channel get oldest: %s", err)
+			time.Sleep(SINK_RETRY_BACKOFF)
+			continue
+		}
+
+		sent, err := h.send(events)
+		h.channel.ConfirmGet(sent)
+		h.confirmed(events[:sent])
+		if err != nil {
+			wait := h.backoff.next(retryAfter(err))
+			log.Printf("httpsink: %s %s: %s, retrying in %s", h.method, h.url, err, wait)
+			time.Sleep(wait)
+		} else {
+			h.backoff.reset()
+		}
+	}
+}
+
+// retryBackoff doubles the wait after every failure in a row, from
+// SINK_RETRY_BACKOFF up to HTTP_SINK_MAX_BACKOFF, unless the server said how
+// long to wait
+type retryBackoff struct {
+	wait time.Duration
+}
+
+func (b *retryBackoff) next(retryAfter time.Duration) time.Duration {
+	if b.wait == 0 {
+		b.wait = SINK_RETRY_BACKOFF
+	} else if b.wait = 2 * b.wait; b.wait > HTTP_SINK_MAX_BACKOFF {
+		b.wait = HTTP_SINK_MAX_BACKOFF
+	}
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	return b.wait
+}
+
+func (b *retryBackoff) reset() {
+	b.wait = 0
+}
+
+// retryAfter is how long a failed response asked to be left alone for
+func retryAfter(err error) time.Duration {
+	if httpErr, ok := err.(*httpSinkError); ok {
+		return httpErr.RetryAfter
+	}
+	return 0
+}
+
+// send delivers events in order, returning how many of them are done with,
+// whether delivered or given up on
+func (h *HttpSink) send(events []Event) (int, error) {
+	if h.batch == "none" {
+		for i := range events {
+			if err := h.sendBatch(events[i : i+1]); err != nil {
+				return i, err
+			}
+		}
+		return len(events), nil
+	}
+
+	if err := h.sendBatch(events); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// sendBatch sends the events the sink hasn't given up on in one request
+func (h *HttpSink) sendBatch(events []Event) error {
+	var body bytes.Buffer
+	jsonBatch := make([]jsonHttpEvent, 0)
+	pending := make([]Event, 0, len(events))
+	for _, event := range events {
+		if h.skip(event) {
+			continue
+		}
+		if h.batch == "json" {
+			jsonBatch = append(jsonBatch, jsonHttpEvent{Headers: event.Headers, Body: string(event.Body)})
+			pending = append(pending, event)
+			continue
+		}
+		record, err := h.serializer.Serialize(event)
+		if err != nil {
+			if h.failed(event, err) {
+				continue
+			}
+			return err
+		}
+		body.Write(record)
+		pending = append(pending, event)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if h.batch == "json" {
+		if err := json.NewEncoder(&body).Encode(jsonBatch); err != nil {
+			return err
+		}
+	}
+
+	err := h.post(body.Bytes())
+	if httpErr, ok := err.(*httpSinkError); ok && !httpErr.Retryable {
+		if h.rejected(pending, err) {
+			return nil
+		}
+	}
+	return err
+}
+
+func (h *HttpSink) post(body []byte) error {
+	if h.gzip {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(body)
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		body = compressed.Bytes()
+	}
+
+	req, err := http.NewRequest(h.method, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range h.headers {
+		req.Header[name] = values
+	}
+	if h.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, HTTP_MAX_BODY_SIZE))
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return newHttpSinkError(resp)
+}
+
+// parseRetryAfter understands Retry-After in seconds or as an HTTP date,
+// returning 0 if it's missing or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func (h *HttpSink) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
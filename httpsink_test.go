@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHttpServer answers requests with the given status codes in turn,
+// then 200s, recording each request and its body
+type fakeHttpServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newFakeHttpServer(codes ...int) *fakeHttpServer {
+	f := &fakeHttpServer{codes: codes}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		f.requests = append(f.requests, r)
+		f.bodies = append(f.bodies, string(body))
+		code := http.StatusOK
+		if len(f.codes) > 0 {
+			code, f.codes = f.codes[0], f.codes[1:]
+		}
+		if code == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(code)
+	}))
+	return f
+}
+
+func TestHttpSinkToHttpSource(t *testing.T) {
+	source, received := initHttpSourceTest(ComponentSettings{"handler": "json"})
+	server := httptest.NewServer(source)
+	defer server.Close()
+
+	sink := NewHttpSink(ComponentSettings{"url": server.URL, "batch": "json", "gzip": "true"}).(*HttpSink)
+	events := makeDummyEvents(3)
+	if sent, err := sink.send(events); err != nil || sent != 3 {
+		t.Fatalf("Expected 3 events sent, got %d: %v", sent, err)
+	}
+
+	_, got, _ := received.GetAll()
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 events received, got %d", len(got))
+	}
+	for i, e := range got {
+		if string(e.Body) != string(events[i].Body) || e.Headers["num"] != events[i].Headers["num"] {
+			t.Errorf("Wrong event received: %+v", e)
+		}
+	}
+}
+
+func TestHttpSinkSingle(t *testing.T) {
+	server := newFakeHttpServer()
+	defer server.Close()
+
+	sink := NewHttpSink(ComponentSettings{
+		"url":              server.URL,
+		"method":           "put",
+		"header.X-Api-Key": "secret",
+	}).(*HttpSink)
+	if sent, err := sink.send(makeDummyEvents(2)); err != nil || sent != 2 {
+		t.Fatalf("Expected 2 events sent, got %d: %v", sent, err)
+	}
+
+	if len(server.requests) != 2 || server.bodies[0] != "Event 0\n" || server.bodies[1] != "Event 1\n" {
+		t.Fatalf("Expected a request per event, got %q", server.bodies)
+	}
+	r := server.requests[0]
+	if r.Method != "PUT" || r.Header.Get("X-Api-Key") != "secret" || r.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("Wrong request %s %v", r.Method, r.Header)
+	}
+}
+
+func TestHttpSinkNdjson(t *testing.T) {
+	server := newFakeHttpServer()
+	defer server.Close()
+
+	sink := NewHttpSink(ComponentSettings{"url": server.URL, "batch": "ndjson", "serializer": "body"}).(*HttpSink)
+	sink.send(makeDummyEvents(2))
+	if len(server.bodies) != 1 || server.bodies[0] != "Event 0\nEvent 1\n" {
+		t.Errorf("Expected one batched request, got %q", server.bodies)
+	}
+	if ct := server.requests[0].Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Wrong content type %s", ct)
+	}
+}
+
+func TestHttpSinkRetry(t *testing.T) {
+	server := newFakeHttpServer(http.StatusOK, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	sink := NewHttpSink(ComponentSettings{"url": server.URL}).(*HttpSink)
+	events := makeDummyEvents(3)
+
+	// the first event is accepted, so only the rest are retried
+	sent, err := sink.send(events)
+	if sent != 1 || err == nil {
+		t.Fatalf("Expected 1 event sent before failing, got %d: %v", sent, err)
+	}
+	if wait := sink.backoff.next(retryAfter(err)); wait != 7*time.Second {
+		t.Errorf("Expected to wait as long as Retry-After, got %s", wait)
+	}
+	if sent, err = sink.send(events[sent:]); sent != 2 || err != nil {
+		t.Errorf("Expected the retry to succeed, got %d: %v", sent, err)
+	}
+	if len(server.bodies) != 4 || server.bodies[2] != "Event 1\n" {
+		t.Errorf("Wrong requests %q", server.bodies)
+	}
+}
+
+func TestHttpSinkBackoff(t *testing.T) {
+	var backoff retryBackoff
+	if wait := backoff.next(0); wait != SINK_RETRY_BACKOFF {
+		t.Errorf("Expected to start at %s, got %s", SINK_RETRY_BACKOFF, wait)
+	}
+	if wait := backoff.next(0); wait != 2*SINK_RETRY_BACKOFF {
+		t.Errorf("Expected the backoff to double, got %s", wait)
+	}
+	for i := 0; i < 20; i++ {
+		backoff.next(0)
+	}
+	if wait := backoff.next(0); wait != HTTP_SINK_MAX_BACKOFF {
+		t.Errorf("Expected the backoff to be capped, got %s", wait)
+	}
+}
+
+func TestHttpSinkRejected(t *testing.T) {
+	server := newFakeHttpServer(http.StatusBadRequest)
+	defer server.Close()
+
+	sink := NewHttpSink(ComponentSettings{"name": "hook", "url": server.URL, "batch": "json"}).(*HttpSink)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetDeadLetter(deadLetter)
+
+	events := makeDummyEvents(2)
+	if sent, err := sink.send(events); sent != 2 || err != nil {
+		t.Fatalf("Expected rejected events to be done with, got %d: %v", sent, err)
+	}
+	n, dead, _ := deadLetter.GetAll()
+	if n != 2 {
+		t.Fatalf("Expected 2 dead lettered events, got %d", n)
+	}
+	checkHeaders(t, dead[0], map[string]string{
+		"DeadLetterSink":     "hook",
+		"DeadLetterError":    "server responded 400 Bad Request",
+		"DeadLetterAttempts": "1",
+	})
+	if len(server.requests) != 1 {
+		t.Errorf("Expected no retries, got %d requests", len(server.requests))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"Sun, 18 Oct 2026 14:00:30 GMT": 30 * time.Second,
+		"Sun, 18 Oct 2026 13:00:00 GMT": 0,
+		"soon":                          0,
+	} {
+		if got := parseRetryAfter(value, now); got != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, value, got)
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -13,9 +18,20 @@ func init() {
 	RegisterSource("http", NewHttpSource)
 }
 
+// HttpSource turns requests on one or more paths into events.  Several http
+// sources configured with the same port share a single listener, so each
+// path on a listener can have its own handler and header settings.
 type HttpSource struct {
-	channels []Channel
-	server   *http.Server
+	channels        []Channel
+	listener        *httpListener
+	handler         HttpHandler
+	capture         *requestCapture
+	maxBodySize     int64
+	retryAfter      int
+	gifResponse     bool
+	corsOrigins     map[string]bool
+	corsHeaders     string
+	corsCredentials bool
 }
 
 func NewHttpSource(config ComponentSettings) Source {
@@ -25,64 +41,286 @@ func NewHttpSource(config ComponentSettings) Source {
 		log.Fatalf("Must configure port for http source")
 	}
 
-	path, ok := config["path"]
+	paths, ok := config["path"]
 	if !ok {
 		log.Fatalf("Must configure path for http source")
 	}
 
-	h := &HttpSource{channels: make([]Channel, 0)}
+	h := &HttpSource{
+		channels:        make([]Channel, 0),
+		maxBodySize:     HTTP_MAX_BODY_SIZE,
+		retryAfter:      HTTP_RETRY_AFTER,
+		gifResponse:     config["gif_response"] == "true",
+		corsOrigins:     make(map[string]bool),
+		corsHeaders:     config["cors_headers"],
+		corsCredentials: config["cors_credentials"] == "true",
+		capture:         newRequestCapture(config),
+	}
 
-	mux := http.NewServeMux()
-	mux.Handle(path, h)
+	handlerName, ok := config["handler"]
+	if !ok {
+		handlerName = "default"
+	}
+	h.handler = NewHttpHandler(handlerName, config)
 
-	h.server = &http.Server{
-		Addr:           fmt.Sprintf(":%s", port),
-		Handler:        mux,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+	if size, ok := config["max_body_size"]; ok {
+		var err error
+		if h.maxBodySize, err = strconv.ParseInt(size, 10, 64); err != nil || h.maxBodySize <= 0 {
+			log.Fatalf("httpsource: invalid max_body_size %s", size)
+		}
 	}
 
-	log.Printf("Starting http sink at http://localhost:%s%s", port, path)
+	if retryAfter, ok := config["retry_after"]; ok {
+		var err error
+		if h.retryAfter, err = strconv.Atoi(retryAfter); err != nil || h.retryAfter < 0 {
+			log.Fatalf("httpsource: invalid retry_after %s", retryAfter)
+		}
+	}
+
+	for _, origin := range splitList(config["cors_origins"]) {
+		h.corsOrigins[origin] = true
+	}
+
+	h.listener = getHttpListener(port)
+	for _, path := range splitList(paths) {
+		if err := h.listener.handle(path, h); err != nil {
+			log.Fatalf("httpsource: %s", err)
+		}
+		log.Printf("Starting http source at http://localhost:%s%s", port, path)
+	}
 	return h
 }
 
+// httpListener is a server shared by all http sources on the same port
+type httpListener struct {
+	server *http.Server
+	mux    *http.ServeMux
+	paths  map[string]bool
+	start  sync.Once
+	err    error
+}
+
+var httpListeners map[string]*httpListener = make(map[string]*httpListener)
+
+func getHttpListener(port string) *httpListener {
+	if listener, ok := httpListeners[port]; ok {
+		return listener
+	}
+
+	mux := http.NewServeMux()
+	listener := &httpListener{
+		mux:   mux,
+		paths: make(map[string]bool),
+		server: &http.Server{
+			Addr:           fmt.Sprintf(":%s", port),
+			Handler:        mux,
+			ReadTimeout:    10 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			MaxHeaderBytes: 1 << 20,
+		},
+	}
+	httpListeners[port] = listener
+	return listener
+}
+
+func (l *httpListener) handle(path string, handler http.Handler) error {
+	if l.paths[path] {
+		return fmt.Errorf("path %s already in use on %s", path, l.server.Addr)
+	}
+	l.paths[path] = true
+	l.mux.Handle(path, handler)
+	return nil
+}
+
+// listen starts serving the first time it's called, every later call gets
+// the result of that first attempt.
+func (l *httpListener) listen() error {
+	l.start.Do(func() {
+		var ln net.Listener
+		ln, l.err = net.Listen("tcp", l.server.Addr)
+		if l.err == nil {
+			go l.server.Serve(ln)
+		}
+	})
+	return l.err
+}
+
 func (h *HttpSource) SetChannel(c Channel) error {
 	h.channels = append(h.channels, c)
 	return nil
 }
 
 func (h *HttpSource) Start() error {
-	go h.server.ListenAndServe()
-	return nil
+	return h.listener.listen()
+}
+
+// httpResponse is the JSON body sent back for every request, unless the
+// source is configured to answer GETs with a tracking pixel.
+type httpResponse struct {
+	Accepted int    `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// emptyGif is the smallest transparent 1x1 gif, for tracking pixel requests
+var emptyGif = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00,
+	0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00,
+	0x00, 0x02, 0x01, 0x44, 0x00, 0x3b,
 }
 
 func (h *HttpSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ts := time.Now().UTC().Unix()
-	var body []byte
-	var err error
-	switch r.Method {
-	case "GET":
-		body = []byte(r.URL.RawQuery)
-	case "POST":
-		body, err = ioutil.ReadAll(r.Body)
+	if h.handleCors(w, r) {
+		return
+	}
+
+	accepted, err := h.handleRequest(w, r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if h.gifResponse && r.Method == "GET" {
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Write(emptyGif)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, httpResponse{Accepted: accepted})
+}
+
+func (h *HttpSource) handleRequest(w http.ResponseWriter, r *http.Request) (int, error) {
+	body, err := h.readBody(w, r)
+	if err != nil {
+		return 0, err
+	}
+	events, err := h.handler.Events(r, body, h.capture.newEvent(r, time.Now().UTC()))
+	if err != nil {
+		return 0, err
+	}
+	if err := h.addEvents(events); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+func (h *HttpSource) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusBadRequest
+	if httpErr, ok := err.(*HttpError); ok {
+		code = httpErr.Code
+		if httpErr.Allow != "" {
+			w.Header().Set("Allow", httpErr.Allow)
+		}
+	}
+	if code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(h.retryAfter))
+	}
+
+	log.Printf("httpsource: %s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, err)
+	writeJSONResponse(w, code, httpResponse{Error: err.Error()})
+}
+
+func writeJSONResponse(w http.ResponseWriter, code int, response httpResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleCors adds CORS headers for allowed origins, returning true if the
+// request was a preflight that has now been answered.
+func (h *HttpSource) handleCors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !h.corsAllowed(origin) {
+		return false
+	}
+
+	if h.corsOrigins["*"] && !h.corsCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	if h.corsCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	if h.corsHeaders != "" {
+		w.Header().Set("Access-Control-Allow-Headers", h.corsHeaders)
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		w.Header().Set("Access-Control-Allow-Headers", requested)
+	}
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(HTTP_CORS_MAX_AGE))
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (h *HttpSource) corsAllowed(origin string) bool {
+	return h.corsOrigins["*"] || h.corsOrigins[origin]
+}
+
+// readBody reads a POST body, undoing any gzip content encoding.  The size
+// limit applies after decompression as well so a small compressed body
+// can't expand without bound.
+func (h *HttpSource) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if r.Method != "POST" {
+		return nil, nil
+	}
+
+	var reader io.Reader = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(reader)
 		if err != nil {
-			log.Printf("Error reading request body: %s", err)
-			return
+			return nil, bodyError(err)
 		}
+		defer gz.Close()
+		reader = io.LimitReader(gz, h.maxBodySize+1)
 	default:
-		log.Printf("Unsupported method: %s", r.Method)
-		return
+		return nil, &HttpError{Code: http.StatusUnsupportedMediaType, Message: fmt.Sprintf("unsupported content encoding %s", r.Header.Get("Content-Encoding"))}
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	if int64(len(body)) > h.maxBodySize {
+		return nil, &HttpError{Code: http.StatusRequestEntityTooLarge, Message: "request body too large"}
+	}
+	return body, nil
+}
+
+func bodyError(err error) error {
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return &HttpError{Code: http.StatusRequestEntityTooLarge, Message: "request body too large"}
+	}
+	return fmt.Errorf("reading request body: %s", err)
+}
+
+// addEvents puts every event from a request with a single AddEvents call per
+// channel, so a channel gets all of a batch or none of it.  That only holds
+// per channel: if a later channel fails the earlier ones keep the batch, and
+// they get it again when the client retries.  With more than one channel
+// delivery is at least once.
+func (h *HttpSource) addEvents(events []Event) error {
+	if len(events) == 0 {
+		return nil
 	}
-	m := NewEvent()
-	m.Body = body
-	m.Headers["Timestamp"] = strconv.FormatInt(ts, 10)
-	m.Headers["Referrer"] = r.Referer()
-	m.Headers["UserAgent"] = r.UserAgent()
-	m.Headers["RemoteAddr"] = r.RemoteAddr
 	for _, channel := range h.channels {
-		channel.AddEvent(m)
+		err := channel.AddEvents(events)
+		if err == ErrChannelFull {
+			return &HttpError{Code: http.StatusServiceUnavailable, Message: err.Error()}
+		}
+		if err != nil {
+			return &HttpError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("adding events: %s", err)}
+		}
 	}
+	return nil
 }
 
 func (h *HttpSource) ReloadConfig(config ComponentSettings) bool {
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var httpSourceTestCount int
+
+func initHttpSourceTest(config ComponentSettings) (*HttpSource, Channel) {
+	// sources share a listener per port, so each test needs its own path
+	httpSourceTestCount++
+	config["port"] = "0"
+	config["path"] = fmt.Sprintf("/test%d/", httpSourceTestCount)
+	source := NewHttpSource(config).(*HttpSource)
+	channel := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(channel)
+	return source, channel
+}
+
+func postToSource(source *HttpSource, body []byte, encoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	if encoding != "" {
+		r.Header.Set("Content-Encoding", encoding)
+	}
+	w := httptest.NewRecorder()
+	source.ServeHTTP(w, r)
+	return w
+}
+
+func TestHttpSourceJsonBatch(t *testing.T) {
+	source, channel := initHttpSourceTest(ComponentSettings{"handler": "json"})
+
+	w := postToSource(source, []byte(`[{"headers": {"a": "1"}, "body": "one"}, {"body": "two"}]`), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	_, events, _ := channel.GetAll()
+	if len(events) != 2 || events[0].Headers["a"] != "1" || string(events[1].Body) != "two" {
+		t.Errorf("Wrong events from batch: %+v", events)
+	}
+
+	w = postToSource(source, []byte(`[{"body": "three"}, {"body": 4}]`), "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid batch, got %d", w.Code)
+	}
+	if count, _, _ := channel.GetAll(); count != 2 {
+		t.Errorf("Invalid batch was partially accepted")
+	}
+}
+
+func TestHttpSourceNdjsonGzip(t *testing.T) {
+	source, channel := initHttpSourceTest(ComponentSettings{"handler": "ndjson"})
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("{\"n\": 1}\n\n{\"n\": 2}\n"))
+	gz.Close()
+
+	w := postToSource(source, buf.Bytes(), "gzip")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	_, events, _ := channel.GetAll()
+	if len(events) != 2 || string(events[1].Body) != `{"n": 2}` {
+		t.Errorf("Wrong events from ndjson: %+v", events)
+	}
+
+	w = postToSource(source, []byte("x"), "br")
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for unknown encoding, got %d", w.Code)
+	}
+}
+
+func TestHttpSourceMaxBodySize(t *testing.T) {
+	source, _ := initHttpSourceTest(ComponentSettings{"max_body_size": "50"})
+
+	w := postToSource(source, bytes.Repeat([]byte("a"), 60), "")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", w.Code)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(bytes.Repeat([]byte("a"), 100))
+	gz.Close()
+	w = postToSource(source, buf.Bytes(), "gzip")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for large decompressed body, got %d", w.Code)
+	}
+}
+
+func TestHttpSourceStatusCodes(t *testing.T) {
+	source, _ := initHttpSourceTest(ComponentSettings{"retry_after": "7"})
+	full := NewMemoryChannel(ComponentSettings{"max_events": "1"})
+	source.SetChannel(full)
+
+	w := postToSource(source, []byte("one"), "")
+	if w.Code != http.StatusOK || w.Body.String() != "{\"accepted\":1}\n" {
+		t.Errorf("Expected 200 with accepted count, got %d: %s", w.Code, w.Body)
+	}
+
+	w = postToSource(source, []byte("two"), "")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "7" {
+		t.Errorf("Expected 503 with Retry-After on full channel, got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	source.ServeHTTP(w, httptest.NewRequest("PUT", "/", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("Expected 405 with Allow header, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestHttpSourceRetryAfterPartialAdd(t *testing.T) {
+	source, first := initHttpSourceTest(ComponentSettings{"handler": "ndjson"})
+	full := NewMemoryChannel(ComponentSettings{"max_events": "3"})
+	full.AddEvents(makeDummyEvents(2))
+	source.SetChannel(full)
+
+	w := postToSource(source, []byte("{}\n{}\n"), "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 on full channel, got %d", w.Code)
+	}
+
+	// once the full channel drains the client's retry succeeds, and the
+	// channel that took the batch the first time gets it twice
+	full.GetAll()
+	full.ConfirmGet(2)
+	if w := postToSource(source, []byte("{}\n{}\n"), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected the retry to succeed, got %d", w.Code)
+	}
+	if count, _, _ := first.GetAll(); count != 4 {
+		t.Errorf("Expected the batch twice in the first channel, got %d events", count)
+	}
+	if count, _, _ := full.GetAll(); count != 2 {
+		t.Errorf("Expected the batch once in the second channel, got %d events", count)
+	}
+}
+
+func TestHttpSourcePixelAndCors(t *testing.T) {
+	source, channel := initHttpSourceTest(ComponentSettings{
+		"gif_response": "true",
+		"cors_origins": "https://example.com",
+	})
+
+	r := httptest.NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	source.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("Bad preflight response: %d %v", w.Code, w.Header())
+	}
+	if count, _, _ := channel.GetAll(); count != 0 {
+		t.Errorf("Preflight request created events")
+	}
+
+	r = httptest.NewRequest("GET", "/?a=1", nil)
+	r.Header.Set("Origin", "https://other.example.com")
+	w = httptest.NewRecorder()
+	source.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" || !bytes.Equal(w.Body.Bytes(), emptyGif) {
+		t.Errorf("Expected gif response, got %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("CORS headers sent for disallowed origin")
+	}
+}
+
+func TestHttpSourceCapture(t *testing.T) {
+	source, channel := initHttpSourceTest(ComponentSettings{
+		"capture_headers": "X-Request-Id:RequestId",
+		"capture_cookies": "session:Session",
+		"capture_query":   "utm_source:Source, missing",
+		"capture_path":    "1:Section",
+		"trusted_proxies": "10.0.0.0/8",
+	})
+
+	r := httptest.NewRequest("GET", "/track/home/42?utm_source=mail", nil)
+	r.RemoteAddr = "10.1.2.3:5555"
+	r.Header.Set("X-Request-Id", "abc")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.9.9.9")
+	r.Header.Set("User-Agent", "test")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	source.ServeHTTP(httptest.NewRecorder(), r)
+
+	_, events, _ := channel.GetAll()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	checkHeaders(t, events[0], map[string]string{
+		"RequestId":  "abc",
+		"Session":    "s1",
+		"Source":     "mail",
+		"Section":    "home",
+		"RemoteAddr": "203.0.113.7",
+	})
+	for _, name := range []string{"UserAgent", "missing"} {
+		if _, ok := events[0].Headers[name]; ok {
+			t.Errorf("Unexpected header %s", name)
+		}
+	}
+}
+
+func TestHttpSourceUntrustedForwardedFor(t *testing.T) {
+	source, channel := initHttpSourceTest(ComponentSettings{"trusted_proxies": "10.0.0.1"})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	source.ServeHTTP(httptest.NewRecorder(), r)
+
+	_, events, _ := channel.GetAll()
+	checkHeaders(t, events[0], map[string]string{"RemoteAddr": "192.0.2.1:1234"})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterInterceptor("timestamp", NewTimestampInterceptor)
+}
+
+// Interceptor inspects or modifies events from a source before they reach
+// its channels.  Returning false drops the event.
+type Interceptor interface {
+	Intercept(Event) (Event, bool)
+}
+
+// Global interceptor registry
+
+var registeredInterceptors map[string]func(ComponentSettings) Interceptor = make(map[string]func(ComponentSettings) Interceptor)
+
+func RegisterInterceptor(name string, constructor func(ComponentSettings) Interceptor) {
+	registeredInterceptors[name] = constructor
+}
+
+func NewInterceptor(name string, config ComponentSettings) Interceptor {
+	constructor, ok := registeredInterceptors[name]
+	if !ok {
+		log.Fatalf("No interceptor registered for name [%s]", name)
+	}
+	return constructor(config)
+}
+
+// interceptedChannel runs a source's interceptors over events before adding
+// them to the underlying channel.  Sources are bound to these in place of
+// the channel itself, so they don't need to know about interceptors.
+type interceptedChannel struct {
+	Channel
+	interceptors []Interceptor
+}
+
+func (i *interceptedChannel) intercept(events []Event) []Event {
+	kept := make([]Event, 0, len(events))
+	for _, e := range events {
+		keep := true
+		for _, interceptor := range i.interceptors {
+			if e, keep = interceptor.Intercept(e); !keep {
+				break
+			}
+		}
+		if keep {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func (i *interceptedChannel) AddEvent(e Event) error {
+	events := i.intercept([]Event{e})
+	if len(events) == 0 {
+		return nil
+	}
+	return i.Channel.AddEvent(events[0])
+}
+
+func (i *interceptedChannel) AddEvents(events []Event) error {
+	events = i.intercept(events)
+	if len(events) == 0 {
+		return nil
+	}
+	return i.Channel.AddEvents(events)
+}
+
+// TimestampInterceptor sets the canonical Timestamp header from a time found
+// in the event body, either a (dotted) field of a JSON body or the first
+// group of a regular expression.  The time is parsed with layout if given,
+// otherwise any of the named timestamp formats is accepted.  Events without
+// a usable time keep the Timestamp their source gave them.
+type TimestampInterceptor struct {
+	field   []string
+	pattern *regexp.Regexp
+	layout  string
+	format  string
+}
+
+func NewTimestampInterceptor(config ComponentSettings) Interceptor {
+	t := &TimestampInterceptor{
+		layout: config["layout"],
+		format: stampFormatSetting("timestamp interceptor", config, TIMESTAMP_UNIX_MS),
+	}
+
+	if field, ok := config["field"]; ok {
+		t.field = strings.Split(field, ".")
+	}
+	if pattern, ok := config["pattern"]; ok {
+		var err error
+		if t.pattern, err = regexp.Compile(pattern); err != nil {
+			log.Fatalf("timestamp interceptor: invalid pattern: %s", err)
+		}
+		if t.pattern.NumSubexp() != 1 {
+			log.Fatal("timestamp interceptor: pattern must have exactly one group")
+		}
+	}
+	if t.field == nil && t.pattern == nil {
+		log.Fatal("timestamp interceptor: must set field or pattern")
+	}
+
+	return t
+}
+
+func (t *TimestampInterceptor) Intercept(e Event) (Event, bool) {
+	ts, ok := t.bodyTime(e.Body)
+	if !ok {
+		if ts, ok = EventTime(e); !ok {
+			ts = time.Now()
+		}
+	}
+
+	// the headers map may be shared with events sent to other channels
+	headers := make(map[string]string, len(e.Headers)+1)
+	for k, v := range e.Headers {
+		headers[k] = v
+	}
+	e.Headers = headers
+	SetEventTime(&e, ts, t.format)
+	return e, true
+}
+
+func (t *TimestampInterceptor) bodyTime(body []byte) (time.Time, bool) {
+	var value string
+	if t.pattern != nil {
+		match := t.pattern.FindSubmatch(body)
+		if match == nil {
+			return time.Time{}, false
+		}
+		value = string(match[1])
+	} else {
+		var ok bool
+		if value, ok = jsonField(body, t.field); !ok {
+			return time.Time{}, false
+		}
+	}
+
+	ts, err := ParseTimestampLayout(value, t.layout)
+	return ts, err == nil
+}
+
+// jsonField returns a string or number found by following path through the
+// objects of a JSON document.
+func jsonField(body []byte, path []string) (string, bool) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return "", false
+	}
+
+	for _, key := range path {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if doc, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch value := doc.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	}
+	return "", false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+
+	"github.com/IBM/sarama"
+)
+
+// newKafkaConfig reads the settings shared by the kafka sink and source:
+// brokers (required, comma separated host:port), version (the brokers'
+// protocol version, KAFKA_VERSION by default) and client_id.
+func newKafkaConfig(component string, config ComponentSettings) ([]string, *sarama.Config) {
+	brokers := splitList(config["brokers"])
+	if len(brokers) == 0 {
+		log.Fatalf("must configure brokers for %s", component)
+	}
+
+	kafkaConfig := sarama.NewConfig()
+	version := KAFKA_VERSION
+	if v, ok := config["version"]; ok {
+		version = v
+	}
+	var err error
+	if kafkaConfig.Version, err = sarama.ParseKafkaVersion(version); err != nil {
+		log.Fatalf("%s: invalid version %s", component, version)
+	}
+	kafkaConfig.ClientID = "collectord"
+	if id, ok := config["client_id"]; ok {
+		kafkaConfig.ClientID = id
+	}
+
+	return brokers, kafkaConfig
+}
+
+// validateKafkaConfig exits if sarama won't accept the settings
+func validateKafkaConfig(component string, kafkaConfig *sarama.Config) {
+	if err := kafkaConfig.Validate(); err != nil {
+		log.Fatalf("%s: %s", component, err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func init() {
+	RegisterSink("kafka", NewKafkaSink)
+}
+
+// KafkaSink produces events to Kafka, batch_size at a time.  The topic and
+// key settings are templates like the file sink's file_name: %Y, %m, %d and
+// %H for the event's time and %{Name} for event headers.  Without a key
+// records have no key.
+//
+// Records are partitioned by a hash of their key, or of the partition_by
+// header if it's set; records without either are spread over partitions at
+// random.  Record values are formatted by the serializer, body by default,
+// without a trailing newline, and the event's headers and ID go in record
+// headers so a kafka source can read the event back as it was.
+//
+// acks is none, leader or all (the default), and idempotent has the brokers
+// drop duplicates of retried records, which needs acks all.  compression is
+// none, gzip, snappy, lz4 or zstd.  Batches are only confirmed in the
+// channel once the brokers have acknowledged every record.  Records the
+// brokers reject as invalid or too large are given up on; any other failure
+// is retried on its own.
+type KafkaSink struct {
+	*deliveries
+	channel     Channel
+	brokers     []string
+	config      *sarama.Config
+	topic       string
+	key         string
+	partitionBy string
+	batchSize   int
+	serializer  Serializer
+	producer    sarama.SyncProducer
+
+	// cancelled by Stop, which waits for done
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// headerPartitioner hashes the partition_by header, passed to it as the
+// record's Metadata, instead of the key
+type headerPartitioner struct {
+	hash sarama.Partitioner
+}
+
+func (p headerPartitioner) Partition(message *sarama.ProducerMessage, partitions int32) (int32, error) {
+	byHeader := &sarama.ProducerMessage{Topic: message.Topic}
+	if value, ok := message.Metadata.(string); ok && value != "" {
+		byHeader.Key = sarama.StringEncoder(value)
+	}
+	return p.hash.Partition(byHeader, partitions)
+}
+
+func (p headerPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+func NewKafkaSink(config ComponentSettings) Sink {
+	brokers, kafkaConfig := newKafkaConfig("kafkasink", config)
+	topic, ok := config["topic"]
+	if !ok {
+		log.Fatal("must configure topic for kafka sink")
+	}
+
+	k := &KafkaSink{
+		deliveries:  newDeliveries("kafkasink", config),
+		brokers:     brokers,
+		config:      kafkaConfig,
+		topic:       topic,
+		key:         config["key"],
+		partitionBy: config["partition_by"],
+		batchSize:   KAFKA_BATCH,
+	}
+
+	for _, template := range []string{k.topic, k.key} {
+		if _, err := expandTemplate(template, time.Now(), func(string) (string, bool) { return "", true }); err != nil {
+			log.Fatalf("kafkasink: invalid template %s: %s", template, err)
+		}
+	}
+
+	serializer := "body"
+	if s, ok := config["serializer"]; ok {
+		serializer = s
+	}
+	k.serializer = NewSerializer(serializer, config)
+
+	if size, ok := config["batch_size"]; ok {
+		var err error
+		if k.batchSize, err = strconv.Atoi(size); err != nil || k.batchSize <= 0 {
+			log.Fatalf("kafkasink: invalid batch_size %s", size)
+		}
+	}
+
+	producer := &kafkaConfig.Producer
+	producer.Return.Successes = true
+	producer.Return.Errors = true
+	if k.partitionBy != "" {
+		producer.Partitioner = func(topic string) sarama.Partitioner {
+			return headerPartitioner{sarama.NewHashPartitioner(topic)}
+		}
+	}
+
+	switch config["acks"] {
+	case "", "all":
+		producer.RequiredAcks = sarama.WaitForAll
+	case "leader":
+		producer.RequiredAcks = sarama.WaitForLocal
+	case "none":
+		producer.RequiredAcks = sarama.NoResponse
+	default:
+		log.Fatalf("kafkasink: invalid acks %s", config["acks"])
+	}
+
+	if config["idempotent"] == "true" {
+		if producer.RequiredAcks != sarama.WaitForAll {
+			log.Fatal("kafkasink: idempotent requires acks all")
+		}
+		producer.Idempotent = true
+		kafkaConfig.Net.MaxOpenRequests = 1
+	}
+
+	if compression, ok := config["compression"]; ok {
+		if err := producer.Compression.UnmarshalText([]byte(compression)); err != nil {
+			log.Fatalf("kafkasink: invalid compression %s", compression)
+		}
+	}
+
+	validateKafkaConfig("kafkasink", kafkaConfig)
+	return k
+}
+
+func (k *KafkaSink) SetChannel(channel Channel) error {
+	k.channel = channel
+	return nil
+}
+
+func (k *KafkaSink) Start() error {
+	if k.channel == nil {
+		return errors.New("kafkasink: no channel set")
+	}
+	k.ctx, k.cancel = context.WithCancel(context.Background())
+	k.done = make(chan struct{})
+	go func() {
+		defer close(k.done)
+		k.deliverForever(k.ctx, k.channel, k.batchSize, k.send)
+	}()
+	return nil
+}
+
+// Stop closes the producer once the batch being sent is done
+func (k *KafkaSink) Stop() error {
+	if k.cancel == nil {
+		return nil
+	}
+	k.cancel()
+	<-k.done
+	if k.producer != nil {
+		return k.producer.Close()
+	}
+	return nil
+}
+
+// send produces a record for each event the sink hasn't given up on,
+// returning the ones to try again.  An error means the whole batch should
+// be sent again.
+func (k *KafkaSink) send(events []Event) ([]Event, error) {
+	messages := make([]*sarama.ProducerMessage, 0, len(events))
+	sent := make(map[*sarama.ProducerMessage]Event)
+	for _, event := range events {
+		if k.skip(event) {
+			continue
+		}
+		message, err := k.message(event)
+		if err != nil {
+			if k.failed(event, err) {
+				continue
+			}
+			return nil, err
+		}
+		messages = append(messages, message)
+		sent[message] = event
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	if k.producer == nil {
+		producer, err := sarama.NewSyncProducer(k.brokers, k.config)
+		if err != nil {
+			return nil, err
+		}
+		k.producer = producer
+	}
+
+	err := k.producer.SendMessages(messages)
+	produceErrors, ok := err.(sarama.ProducerErrors)
+	if err != nil && !ok {
+		return nil, err
+	}
+
+	failed := make(map[*sarama.ProducerMessage]bool)
+	for _, produceErr := range produceErrors {
+		if kafkaRejected(produceErr.Err) && k.rejected([]Event{sent[produceErr.Msg]}, produceErr.Err) {
+			continue
+		}
+		failed[produceErr.Msg] = true
+	}
+	retry := make([]Event, 0)
+	for _, message := range messages {
+		if failed[message] {
+			retry = append(retry, sent[message])
+		}
+	}
+	return retry, nil
+}
+
+// kafkaRejected reports whether the brokers will never accept a record
+func kafkaRejected(err error) bool {
+	switch err {
+	case sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidMessage, sarama.ErrInvalidMessageSize,
+		sarama.ErrInvalidRecord, sarama.ErrInvalidTopic:
+		return true
+	}
+	return false
+}
+
+// message builds the record for an event
+func (k *KafkaSink) message(event Event) (*sarama.ProducerMessage, error) {
+	value, err := k.serializer.Serialize(event)
+	if err != nil {
+		return nil, err
+	}
+
+	t := eventTimeOrNow(event, time.Now())
+	lookup := func(header string) (string, bool) {
+		return event.Headers[header], true
+	}
+	topic, _ := expandTemplate(k.topic, t, lookup)
+	message := &sarama.ProducerMessage{
+		Topic:     topic,
+		Value:     sarama.ByteEncoder(bytes.TrimSuffix(value, []byte("\n"))),
+		Timestamp: t,
+		Metadata:  event.Headers[k.partitionBy],
+	}
+	if k.key != "" {
+		key, _ := expandTemplate(k.key, t, lookup)
+		message.Key = sarama.StringEncoder(key)
+	}
+
+	message.Headers = make([]sarama.RecordHeader, 0, len(event.Headers)+1)
+	message.Headers = append(message.Headers, sarama.RecordHeader{
+		Key:   []byte(KAFKA_EVENT_ID_HEADER),
+		Value: []byte(event.ID),
+	})
+	for name, value := range event.Headers {
+		message.Headers = append(message.Headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(value)})
+	}
+	return message, nil
+}
+
+func (k *KafkaSink) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeProducer records the records it's sent, failing those fail returns an
+// error for
+type fakeProducer struct {
+	sarama.SyncProducer
+	lock     sync.Mutex
+	fail     func(message *sarama.ProducerMessage) error
+	messages []*sarama.ProducerMessage
+	closed   bool
+}
+
+func (p *fakeProducer) SendMessages(messages []*sarama.ProducerMessage) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var errs sarama.ProducerErrors
+	for _, message := range messages {
+		if p.fail != nil {
+			if err := p.fail(message); err != nil {
+				errs = append(errs, &sarama.ProducerError{Msg: message, Err: err})
+				continue
+			}
+		}
+		p.messages = append(p.messages, message)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *fakeProducer) produced() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.messages)
+}
+
+func (p *fakeProducer) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closed = true
+	return nil
+}
+
+func recordHeaders(message *sarama.ProducerMessage) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range message.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	return headers
+}
+
+func TestKafkaSinkMessages(t *testing.T) {
+	sink := NewKafkaSink(ComponentSettings{
+		"brokers": "localhost:9092",
+		"topic":   "logs-%{app}",
+		"key":     "%{host}/%Y",
+	}).(*KafkaSink)
+	producer := &fakeProducer{}
+	sink.producer = producer
+
+	events := makeDummyEvents(2)
+	for i := range events {
+		events[i].Headers["app"] = "web"
+		events[i].Headers["host"] = "a"
+		events[i].Headers["Timestamp"] = "2026-10-18T14:00:00Z"
+	}
+	if retry, err := sink.send(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event produced, got %d to retry: %v", len(retry), err)
+	}
+
+	if len(producer.messages) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(producer.messages))
+	}
+	message := producer.messages[1]
+	key, _ := message.Key.Encode()
+	value, _ := message.Value.Encode()
+	if message.Topic != "logs-web" || string(key) != "a/2026" || string(value) != "Event 1" {
+		t.Errorf("Wrong record %s %s %q", message.Topic, key, value)
+	}
+	if !message.Timestamp.Equal(time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the event's time, got %s", message.Timestamp)
+	}
+	headers := recordHeaders(message)
+	if headers[KAFKA_EVENT_ID_HEADER] != events[1].ID || headers["num"] != "1" || headers["app"] != "web" {
+		t.Errorf("Wrong record headers %v", headers)
+	}
+}
+
+func TestKafkaSinkRetriesAndRejects(t *testing.T) {
+	sink := NewKafkaSink(ComponentSettings{
+		"name":    "backbone",
+		"brokers": "localhost:9092",
+		"topic":   "logs",
+	}).(*KafkaSink)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetDeadLetter(deadLetter)
+
+	// "Event 1" times out once and "Event 2" is too large
+	timedOut := false
+	producer := &fakeProducer{fail: func(message *sarama.ProducerMessage) error {
+		value, _ := message.Value.Encode()
+		switch {
+		case string(value) == "Event 1" && !timedOut:
+			timedOut = true
+			return sarama.ErrRequestTimedOut
+		case string(value) == "Event 2":
+			return sarama.ErrMessageSizeTooLarge
+		}
+		return nil
+	}}
+	sink.producer = producer
+
+	events := makeDummyEvents(3)
+	retry, err := sink.send(events)
+	if err != nil || len(retry) != 1 || retry[0].ID != events[1].ID {
+		t.Fatalf("Expected event 1 retried, got %d: %v", len(retry), err)
+	}
+	if retry, err = sink.send(retry); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected the retry to succeed, got %d: %v", len(retry), err)
+	}
+	if len(producer.messages) != 2 {
+		t.Errorf("Expected 2 records produced, got %d", len(producer.messages))
+	}
+
+	n, dead, _ := deadLetter.GetAll()
+	if n != 1 || dead[0].ID != events[2].ID {
+		t.Fatalf("Expected event 2 dead lettered, got %d", n)
+	}
+	checkHeaders(t, dead[0], map[string]string{
+		"DeadLetterSink":  "backbone",
+		"DeadLetterError": sarama.ErrMessageSizeTooLarge.Error(),
+	})
+}
+
+func TestKafkaSinkProducerFailure(t *testing.T) {
+	sink := NewKafkaSink(ComponentSettings{"brokers": "localhost:9092", "topic": "logs"}).(*KafkaSink)
+	failing := &failingProducer{err: errors.New("client has run out of available brokers")}
+	sink.producer = failing
+	if _, err := sink.send(makeDummyEvents(2)); err != failing.err {
+		t.Errorf("Expected the batch to fail, got %v", err)
+	}
+}
+
+type failingProducer struct {
+	sarama.SyncProducer
+	err error
+}
+
+func (p *failingProducer) SendMessages([]*sarama.ProducerMessage) error {
+	return p.err
+}
+
+func TestKafkaSinkPartitionByHeader(t *testing.T) {
+	sink := NewKafkaSink(ComponentSettings{
+		"brokers":      "localhost:9092",
+		"topic":        "logs",
+		"partition_by": "tenant",
+	}).(*KafkaSink)
+	partitioner := sink.config.Producer.Partitioner("logs")
+
+	partitionOf := func(tenant string, num int) int32 {
+		e := NewEvent()
+		e.Headers["tenant"] = tenant
+		e.Body = []byte{byte(num)}
+		message, err := sink.message(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partition, err := partitioner.Partition(message, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return partition
+	}
+
+	for _, tenant := range []string{"a", "b", "c"} {
+		first := partitionOf(tenant, 0)
+		for i := 1; i < 10; i++ {
+			if partition := partitionOf(tenant, i); partition != first {
+				t.Errorf("Expected tenant %s on partition %d, got %d", tenant, first, partition)
+			}
+		}
+	}
+}
+
+func TestKafkaSinkProducerSettings(t *testing.T) {
+	sink := NewKafkaSink(ComponentSettings{
+		"brokers":     "a:9092, b:9092",
+		"topic":       "logs",
+		"idempotent":  "true",
+		"compression": "zstd",
+	}).(*KafkaSink)
+	producer := sink.config.Producer
+	if !producer.Idempotent || producer.RequiredAcks != sarama.WaitForAll || producer.Compression != sarama.CompressionZSTD {
+		t.Errorf("Wrong producer settings %+v", producer)
+	}
+	if sink.config.Net.MaxOpenRequests != 1 || len(sink.brokers) != 2 {
+		t.Errorf("Wrong client settings %d %v", sink.config.Net.MaxOpenRequests, sink.brokers)
+	}
+
+	sink = NewKafkaSink(ComponentSettings{"brokers": "a:9092", "topic": "logs", "acks": "leader"}).(*KafkaSink)
+	if sink.config.Producer.RequiredAcks != sarama.WaitForLocal {
+		t.Errorf("Expected leader acks, got %d", sink.config.Producer.RequiredAcks)
+	}
+}
+
+func TestKafkaSinkStopClosesProducer(t *testing.T) {
+	sink := NewKafkaSink(ComponentSettings{"brokers": "localhost:9092", "topic": "logs"}).(*KafkaSink)
+	producer := &fakeProducer{}
+	sink.producer = producer
+	channel := NewMemoryChannel(ComponentSettings{})
+	channel.AddEvents(makeDummyEvents(3))
+	sink.SetChannel(channel)
+	sink.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for producer.produced() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for events to be produced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sink.Stop()
+	if !producer.closed || len(producer.messages) != 3 {
+		t.Errorf("Expected 3 records and the producer closed, got %d %t", len(producer.messages), producer.closed)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func init() {
+	RegisterSource("kafka", NewKafkaSource)
+}
+
+// KafkaSource consumes the comma separated topics as a member of the
+// consumer group group.  Each record becomes an event with the record's
+// value as its body and its headers as event headers, along with Topic,
+// Partition, Offset and Key (if it has one).  Records written by a kafka
+// sink keep their event ID, and records without a Timestamp header are
+// stamped with the record's time.
+//
+// Records are added to the channels up to batch_size at a time, and their
+// offsets are only committed once every channel has taken them, so a full
+// channel holds the partition back and a crash means records are consumed
+// again rather than lost.  initial_offset is where a group with no
+// committed offset starts reading: newest (the default) or oldest.
+type KafkaSource struct {
+	channels        []Channel
+	brokers         []string
+	config          *sarama.Config
+	group           string
+	topics          []string
+	batchSize       int
+	timestampFormat string
+	consumer        sarama.ConsumerGroup
+
+	// cancelled by Stop, which waits for done
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewKafkaSource(config ComponentSettings) Source {
+	brokers, kafkaConfig := newKafkaConfig("kafkasource", config)
+	group, ok := config["group"]
+	if !ok {
+		log.Fatal("must configure group for kafka source")
+	}
+	topics := splitList(config["topics"])
+	if len(topics) == 0 {
+		log.Fatal("must configure topics for kafka source")
+	}
+
+	k := &KafkaSource{
+		channels:        make([]Channel, 0),
+		brokers:         brokers,
+		config:          kafkaConfig,
+		group:           group,
+		topics:          topics,
+		batchSize:       KAFKA_BATCH,
+		timestampFormat: stampFormatSetting("kafkasource", config, TIMESTAMP_UNIX_MS),
+	}
+
+	if size, ok := config["batch_size"]; ok {
+		var err error
+		if k.batchSize, err = strconv.Atoi(size); err != nil || k.batchSize <= 0 {
+			log.Fatalf("kafkasource: invalid batch_size %s", size)
+		}
+	}
+
+	consumer := &kafkaConfig.Consumer
+	consumer.Return.Errors = true
+	// offsets are committed by hand once the channels have the records
+	consumer.Offsets.AutoCommit.Enable = false
+	switch config["initial_offset"] {
+	case "", "newest":
+		consumer.Offsets.Initial = sarama.OffsetNewest
+	case "oldest":
+		consumer.Offsets.Initial = sarama.OffsetOldest
+	default:
+		log.Fatalf("kafkasource: invalid initial_offset %s", config["initial_offset"])
+	}
+
+	validateKafkaConfig("kafkasource", kafkaConfig)
+	return k
+}
+
+func (k *KafkaSource) SetChannel(channel Channel) error {
+	k.channels = append(k.channels, channel)
+	return nil
+}
+
+func (k *KafkaSource) Start() error {
+	k.ctx, k.cancel = context.WithCancel(context.Background())
+	k.done = make(chan struct{})
+	go k.consumeForever()
+	return nil
+}
+
+// Stop leaves the consumer group once the batch being added is done
+func (k *KafkaSource) Stop() error {
+	if k.cancel == nil {
+		return nil
+	}
+	k.cancel()
+	<-k.done
+	return nil
+}
+
+func (k *KafkaSource) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
+
+func (k *KafkaSource) consumeForever() {
+	defer close(k.done)
+	defer func() {
+		if k.consumer != nil {
+			k.consumer.Close()
+		}
+	}()
+
+	for k.ctx.Err() == nil {
+		if k.consumer == nil {
+			consumer, err := sarama.NewConsumerGroup(k.brokers, k.group, k.config)
+			if err != nil {
+				log.Printf("kafkasource: joining group %s: %s", k.group, err)
+				k.sleep(KAFKA_RETRY_BACKOFF)
+				continue
+			}
+			k.consumer = consumer
+			go func() {
+				for err := range consumer.Errors() {
+					log.Printf("kafkasource: %s", err)
+				}
+			}()
+		}
+
+		// Consume returns whenever the group rebalances
+		if err := k.consumer.Consume(k.ctx, k.topics, k); err != nil && k.ctx.Err() == nil {
+			log.Printf("kafkasource: consume: %s", err)
+			k.sleep(KAFKA_RETRY_BACKOFF)
+		}
+	}
+}
+
+// sleep waits for d or until the source is stopped
+func (k *KafkaSource) sleep(d time.Duration) {
+	select {
+	case <-k.ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func (k *KafkaSource) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (k *KafkaSource) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim adds a partition's records to the channels, committing their
+// offsets once they're in every channel
+func (k *KafkaSource) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		var batch []*sarama.ConsumerMessage
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			batch = append(batch, message)
+		case <-ctx.Done():
+			return nil
+		}
+		// take whatever else has already arrived
+	more:
+		for len(batch) < k.batchSize {
+			select {
+			case message, ok := <-claim.Messages():
+				if !ok {
+					break more
+				}
+				batch = append(batch, message)
+			default:
+				break more
+			}
+		}
+
+		events := make([]Event, len(batch))
+		for i, message := range batch {
+			events[i] = k.newEvent(message)
+		}
+		if !k.addEvents(ctx, events) {
+			return nil
+		}
+		session.MarkMessage(batch[len(batch)-1], "")
+		session.Commit()
+	}
+}
+
+// addEvents adds events to every channel, retrying channels that are full
+// until ctx is done.  Channels that have taken the events aren't given them
+// again.
+func (k *KafkaSource) addEvents(ctx context.Context, events []Event) bool {
+	for i := 0; i < len(k.channels); {
+		if err := k.channels[i].AddEvents(events); err != nil {
+			log.Printf("kafkasource: adding %d events: %s, retrying in %s", len(events), err, KAFKA_RETRY_BACKOFF)
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(KAFKA_RETRY_BACKOFF):
+			}
+			continue
+		}
+		i++
+	}
+	return true
+}
+
+func (k *KafkaSource) newEvent(message *sarama.ConsumerMessage) Event {
+	e := NewEvent()
+	e.Body = message.Value
+	if e.Body == nil {
+		e.Body = make([]byte, 0)
+	}
+	for _, header := range message.Headers {
+		if header == nil {
+			continue
+		}
+		if string(header.Key) == KAFKA_EVENT_ID_HEADER {
+			if len(header.Value) > 0 {
+				e.ID = string(header.Value)
+			}
+			continue
+		}
+		e.Headers[string(header.Key)] = string(header.Value)
+	}
+
+	e.Headers["Topic"] = message.Topic
+	e.SetHeader("Partition", IntValue(int64(message.Partition)))
+	e.SetHeader("Offset", IntValue(message.Offset))
+	if message.Key != nil {
+		e.Headers["Key"] = string(message.Key)
+	}
+
+	t := message.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	stampEvent(&e, t, k.timestampFormat)
+	return e
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeSession records the offsets marked and committed by a consumer
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx       context.Context
+	lock      sync.Mutex
+	marked    int64
+	committed []int64
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) MarkMessage(message *sarama.ConsumerMessage, metadata string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.marked = message.Offset + 1
+}
+
+func (s *fakeSession) Commit() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.committed = append(s.committed, s.marked)
+}
+
+func (s *fakeSession) commits() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int64(nil), s.committed...)
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func newKafkaSourceTest(extra ComponentSettings) *KafkaSource {
+	config := ComponentSettings{"brokers": "localhost:9092", "group": "collectord", "topics": "logs"}
+	for name, value := range extra {
+		config[name] = value
+	}
+	return NewKafkaSource(config).(*KafkaSource)
+}
+
+func TestKafkaSourceEvents(t *testing.T) {
+	source := newKafkaSourceTest(nil)
+	ts := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+
+	e := source.newEvent(&sarama.ConsumerMessage{
+		Topic:     "logs",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("a"),
+		Value:     []byte("hello"),
+		Timestamp: ts,
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(KAFKA_EVENT_ID_HEADER), Value: []byte("abc123")},
+			{Key: []byte("app"), Value: []byte("web")},
+		},
+	})
+	if e.ID != "abc123" || string(e.Body) != "hello" {
+		t.Errorf("Wrong event %+v", e)
+	}
+	checkHeaders(t, e, map[string]string{
+		"Topic":     "logs",
+		"Partition": "3",
+		"Offset":    "42",
+		"Key":       "a",
+		"app":       "web",
+		"Timestamp": FormatTimestamp(ts, TIMESTAMP_UNIX_MS),
+	})
+	if _, ok := e.Headers[KAFKA_EVENT_ID_HEADER]; ok {
+		t.Errorf("Expected the ID header removed, got %v", e.Headers)
+	}
+
+	// a Timestamp header from a kafka sink wins over the record's time
+	e = source.newEvent(&sarama.ConsumerMessage{
+		Timestamp: ts,
+		Headers:   []*sarama.RecordHeader{{Key: []byte("Timestamp"), Value: []byte("2026-01-02T03:04:05Z")}},
+	})
+	if t0, _ := EventTime(e); !t0.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Expected the header's time, got %s", e.Headers["Timestamp"])
+	}
+	if _, ok := e.Headers["Key"]; ok || e.ID == "" {
+		t.Errorf("Expected no key and a new ID, got %+v", e)
+	}
+}
+
+func TestKafkaSourceCommitsAfterPut(t *testing.T) {
+	source := newKafkaSourceTest(ComponentSettings{"batch_size": "2"})
+	full := NewMemoryChannel(ComponentSettings{"max_events": "2"})
+	other := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(other)
+	source.SetChannel(full)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &fakeSession{ctx: ctx}
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 10)}
+	for i := 0; i < 3; i++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "logs", Offset: int64(i), Value: []byte("record")}
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- source.ConsumeClaim(session, claim)
+	}()
+
+	// the first batch fits, the second waits for room in the full channel
+	deadline := time.Now().Add(5 * time.Second)
+	for len(session.commits()) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first commit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if commits := session.commits(); len(commits) != 1 || commits[0] != 2 {
+		t.Fatalf("Expected only offset 2 committed, got %v", commits)
+	}
+
+	full.GetAll()
+	full.ConfirmGet(2)
+	for len(session.commits()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the second commit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if commits := session.commits(); commits[1] != 3 {
+		t.Errorf("Expected offset 3 committed, got %v", commits)
+	}
+	if n, _, _ := other.GetAll(); n != 3 {
+		t.Errorf("Expected each event added to the other channel once, got %d", n)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected the claim to end cleanly, got %s", err)
+	}
+}
+
+// TestKafkaRoundTrip produces and consumes through the single node broker
+// at COLLECTORD_KAFKA_BROKERS, if it's set
+func TestKafkaRoundTrip(t *testing.T) {
+	brokers := os.Getenv("COLLECTORD_KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("COLLECTORD_KAFKA_BROKERS not set")
+	}
+	topic := "collectord-test-" + newEventID()[:8]
+
+	sink := NewKafkaSink(ComponentSettings{
+		"brokers":     brokers,
+		"topic":       topic,
+		"idempotent":  "true",
+		"compression": "gzip",
+	}).(*KafkaSink)
+	events := makeDummyEvents(5)
+	if retry, err := sink.send(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Failed to produce: %d to retry: %v", len(retry), err)
+	}
+	sink.producer.Close()
+
+	source := NewKafkaSource(ComponentSettings{
+		"brokers":        brokers,
+		"group":          topic,
+		"topics":         topic,
+		"initial_offset": "oldest",
+	}).(*KafkaSource)
+	channel := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(channel)
+	source.Start()
+	defer source.Stop()
+
+	received := make(map[string]Event)
+	deadline := time.Now().Add(30 * time.Second)
+	for len(received) < len(events) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out with %d of %d events consumed", len(received), len(events))
+		}
+		channel.WaitForEvents(context.Background(), time.Second)
+		n, got, _ := channel.GetAll()
+		channel.ConfirmGet(n)
+		for _, e := range got {
+			received[e.ID] = e
+		}
+	}
+	for _, e := range events {
+		if got, ok := received[e.ID]; !ok || string(got.Body) != string(e.Body) || got.Headers["num"] != e.Headers["num"] {
+			t.Errorf("Wrong event for %s: %+v", e.ID, got)
+		}
+	}
+}
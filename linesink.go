@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+func init() {
+	RegisterSink("tcp", NewLineSink)
+}
+
+// LineSink writes serialized events to a TCP connection, the counterpart of
+// the tcp source.  The body serializer, the default, sends newline
+// terminated bodies the tcp source reads back as events.  Begin is sent on
+// every new connection.
+type LineSink struct {
+	*deliveries
+	channel    Channel
+	addr       string
+	serializer Serializer
+	conn       net.Conn
+	buf        *bufio.Writer
+}
+
+func NewLineSink(config ComponentSettings) Sink {
+	host, ok := config["host"]
+	if !ok {
+		log.Fatal("must configure host for tcp sink")
+	}
+	port, ok := config["port"]
+	if !ok {
+		log.Fatal("must configure port for tcp sink")
+	}
+
+	serializer := "body"
+	if s, ok := config["serializer"]; ok {
+		serializer = s
+	}
+
+	return &LineSink{
+		deliveries: newDeliveries("tcpsink", config),
+		addr:       net.JoinHostPort(host, port),
+		serializer: NewSerializer(serializer, config),
+	}
+}
+
+func (l *LineSink) SetChannel(channel Channel) error {
+	l.channel = channel
+	return nil
+}
+
+func (l *LineSink) Start() error {
+	if l.channel == nil {
+		return errors.New("tcpsink: no channel set")
+	}
+	go l.loopForever()
+	return nil
+}
+
+func (l *LineSink) connect() error {
+	conn, err := net.Dial("tcp", l.addr)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	l.buf = bufio.NewWriter(conn)
+	if _, err := l.buf.Write(l.serializer.Begin()); err != nil {
+		l.disconnect()
+		return err
+	}
+	return nil
+}
+
+func (l *LineSink) disconnect() {
+	l.conn.Close()
+	l.conn = nil
+	l.buf = nil
+}
+
+func (l *LineSink) loopForever() {
+	for {
+		if !l.channel.WaitForEvents(context.Background(), SINK_IDLE_TIMEOUT) {
+			continue
+		}
+		if l.conn == nil {
+			if err := l.connect(); err != nil {
+				log.Printf("tcpsink: connect to %s: %s", l.addr, err)
+				time.Sleep(SINK_RETRY_BACKOFF)
+				continue
+			}
+		}
+
+		count, events, err := l.channel.GetAll()
+		if err != nil {
+			log.Printf("tcpsink: channel get all: %s", err)
+			time.Sleep(SINK_RETRY_BACKOFF)
+			continue
+		}
+
+		if err = l.send(events); err != nil {
+			log.Printf("tcpsink: send: %s", err)
+			l.channel.ConfirmGet(0)
+			time.Sleep(SINK_RETRY_BACKOFF)
+			continue
+		}
+		l.channel.ConfirmGet(count)
+		l.confirmed(events)
+	}
+}
+
+// send writes every event the sink hasn't given up on.  The batch is
+// serialized first so a bad event can't leave part of it buffered, and
+// serialization errors count against the event while write errors drop the
+// connection.
+func (l *LineSink) send(events []Event) error {
+	records := make([][]byte, 0, len(events))
+	for _, event := range events {
+		if l.skip(event) {
+			continue
+		}
+		record, err := l.serializer.Serialize(event)
+		if err != nil {
+			if l.failed(event, err) {
+				continue
+			}
+			return err
+		}
+		records = append(records, record)
+	}
+
+	for _, record := range records {
+		if _, err := l.buf.Write(record); err != nil {
+			l.disconnect()
+			return err
+		}
+	}
+	if err := l.buf.Flush(); err != nil {
+		l.disconnect()
+		return err
+	}
+	return nil
+}
+
+func (l *LineSink) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
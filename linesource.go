@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+func init() {
+	RegisterSource("tcp", func(config ComponentSettings) Source { return NewLineSource("tcp", config) })
+	RegisterSource("udp", func(config ComponentSettings) Source { return NewLineSource("udp", config) })
+}
+
+// LineSource accepts framed records over plain TCP or UDP, one event per
+// record, so that anything that can write to a socket (netcat included) can
+// send events.  Records are newline terminated by default, or may use a
+// configurable delimiter or a 4 byte big endian length prefix.
+type LineSource struct {
+	channels        []Channel
+	protocol        string
+	name            string
+	addr            string
+	split           bufio.SplitFunc
+	maxLineLength   int
+	maxConnections  int
+	connections     chan struct{}
+	timestampFormat string
+}
+
+func NewLineSource(protocol string, config ComponentSettings) Source {
+	port, ok := config["port"]
+	if !ok {
+		log.Fatalf("must set port for %s source", protocol)
+	}
+
+	l := &LineSource{
+		channels:      make([]Channel, 0),
+		protocol:      protocol,
+		name:          config["name"],
+		addr:          fmt.Sprintf("%s:%s", config["host"], port),
+		split:         scanNewlineFrames,
+		maxLineLength: LINE_MAX_LENGTH,
+
+		timestampFormat: stampFormatSetting(protocol+"source", config, TIMESTAMP_UNIX_MS),
+	}
+
+	switch config["framing"] {
+	case "", "newline":
+	case "delimiter":
+		delim, err := strconv.Unquote(`"` + config["delimiter"] + `"`)
+		if err != nil || delim == "" {
+			log.Fatalf("%ssource: invalid delimiter %q", protocol, config["delimiter"])
+		}
+		l.split = delimiterFrames([]byte(delim))
+	case "length":
+		l.split = scanLengthPrefixedFrames
+	default:
+		log.Fatalf("%ssource: unknown framing %s", protocol, config["framing"])
+	}
+
+	if length, ok := config["max_line_length"]; ok {
+		var err error
+		if l.maxLineLength, err = strconv.Atoi(length); err != nil || l.maxLineLength <= 0 {
+			log.Fatalf("%ssource: invalid max_line_length %s", protocol, length)
+		}
+	}
+
+	if max, ok := config["max_connections"]; ok {
+		var err error
+		if l.maxConnections, err = strconv.Atoi(max); err != nil || l.maxConnections < 0 {
+			log.Fatalf("%ssource: invalid max_connections %s", protocol, max)
+		}
+	}
+	if l.maxConnections > 0 {
+		l.connections = make(chan struct{}, l.maxConnections)
+	}
+
+	return l
+}
+
+func (l *LineSource) SetChannel(channel Channel) error {
+	l.channels = append(l.channels, channel)
+	return nil
+}
+
+func (l *LineSource) Start() error {
+	if l.protocol == "udp" {
+		conn, err := net.ListenPacket("udp", l.addr)
+		if err != nil {
+			return err
+		}
+		log.Printf("udpsource: listening on %s", l.addr)
+		go l.serveUDP(conn)
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", l.addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("tcpsource: listening on %s", l.addr)
+	go l.serveTCP(ln)
+	return nil
+}
+
+func (l *LineSource) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("udpsource: read: %s", err)
+			continue
+		}
+
+		// a datagram may hold several records, each split as if at EOF
+		remote := addr.String()
+		data := buf[:n]
+		for len(data) > 0 {
+			advance, record, err := l.split(data, true)
+			if err != nil {
+				log.Printf("udpsource: datagram from %s: %s", remote, err)
+				break
+			}
+			if advance == 0 {
+				break
+			}
+			data = data[advance:]
+			if len(record) > l.maxLineLength {
+				log.Printf("udpsource: dropping record of %d bytes from %s", len(record), remote)
+				continue
+			}
+			l.addRecord(record, remote)
+		}
+	}
+}
+
+func (l *LineSource) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("tcpsource: failed to accept connection: %s", err)
+			continue
+		}
+
+		if l.connections != nil {
+			select {
+			case l.connections <- struct{}{}:
+			default:
+				log.Printf("tcpsource: rejecting %s, already at %d connections", conn.RemoteAddr(), l.maxConnections)
+				conn.Close()
+				continue
+			}
+		}
+		go l.handleConn(conn)
+	}
+}
+
+func (l *LineSource) handleConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		if l.connections != nil {
+			<-l.connections
+		}
+	}()
+
+	remote := conn.RemoteAddr().String()
+	scanner := bufio.NewScanner(conn)
+	// length prefixed frames carry 4 extra bytes through the scanner buffer
+	scanner.Buffer(make([]byte, 0, 4096), l.maxLineLength+4)
+	scanner.Split(l.split)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > l.maxLineLength {
+			log.Printf("tcpsource: record from %s exceeds max_line_length", remote)
+			return
+		}
+		l.addRecord(scanner.Bytes(), remote)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("tcpsource: connection from %s: %s", remote, err)
+	}
+}
+
+func (l *LineSource) addRecord(record []byte, remote string) {
+	if len(record) == 0 {
+		return
+	}
+
+	e := NewEvent()
+	e.Body = append([]byte(nil), record...)
+	SetEventTime(&e, time.Now(), l.timestampFormat)
+	e.Headers["RemoteAddr"] = remote
+	e.Headers["Listener"] = l.name
+	for _, channel := range l.channels {
+		if err := channel.AddEvent(e); err != nil {
+			log.Printf("%ssource: add event: %s", l.protocol, err)
+		}
+	}
+}
+
+func (l *LineSource) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanFramingVariants(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("a||b||c"))
+	scanner.Split(delimiterFrames([]byte("||")))
+	for _, frame := range []string{"a", "b", "c"} {
+		if !scanner.Scan() || scanner.Text() != frame {
+			t.Errorf("delimiter: expected %q, got %q (%v)", frame, scanner.Text(), scanner.Err())
+		}
+	}
+
+	scanner = bufio.NewScanner(strings.NewReader("\x00\x00\x00\x03one\x00\x00\x00\x00\x00\x00\x00\x05tw"))
+	scanner.Split(scanLengthPrefixedFrames)
+	for _, frame := range []string{"one", ""} {
+		if !scanner.Scan() || scanner.Text() != frame {
+			t.Errorf("length: expected %q, got %q (%v)", frame, scanner.Text(), scanner.Err())
+		}
+	}
+	if scanner.Scan() || scanner.Err() != ErrBadFrameLength {
+		t.Errorf("length: expected truncated frame error, got %v", scanner.Err())
+	}
+}
+
+// waitForBodies reads events from c until it has count of them
+func waitForBodies(t *testing.T, c Channel, count int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	bodies := make([]string, 0, count)
+	for len(bodies) < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out with %d of %d events: %q", len(bodies), count, bodies)
+		}
+		c.WaitForEvents(context.Background(), 100*time.Millisecond)
+		n, events, _ := c.GetAll()
+		c.ConfirmGet(n)
+		for _, e := range events {
+			bodies = append(bodies, string(e.Body))
+		}
+	}
+	return bodies
+}
+
+func TestLineSourceTCP(t *testing.T) {
+	source := NewLineSource("tcp", ComponentSettings{"port": "0", "name": "app"}).(*LineSource)
+	channel := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(channel)
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		source.handleConn(server)
+		close(done)
+	}()
+	client.Write([]byte("one\ntwo\r\n\nthree"))
+	client.Close()
+	<-done
+
+	n, events, _ := channel.GetAll()
+	if n != 3 {
+		t.Fatalf("Expected 3 events, got %d", n)
+	}
+	for i, body := range []string{"one", "two", "three"} {
+		if string(events[i].Body) != body {
+			t.Errorf("Event %d: expected %q, got %q", i, body, events[i].Body)
+		}
+	}
+	checkHeaders(t, events[0], map[string]string{"Listener": "app", "RemoteAddr": "pipe"})
+}
+
+func TestLineSourceLengthFraming(t *testing.T) {
+	source := NewLineSource("tcp", ComponentSettings{
+		"port":            "0",
+		"framing":         "length",
+		"max_line_length": "5",
+	}).(*LineSource)
+	channel := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(channel)
+
+	// the second record is too long, which ends the connection
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		source.handleConn(server)
+		close(done)
+	}()
+	go func() {
+		client.Write([]byte("\x00\x00\x00\x04a\nbc\x00\x00\x00\x06abcdef\x00\x00\x00\x01z"))
+		client.Close()
+	}()
+	<-done
+
+	n, events, _ := channel.GetAll()
+	if n != 1 || string(events[0].Body) != "a\nbc" {
+		t.Fatalf("Expected only the first record, got %d events", n)
+	}
+}
+
+func TestLineSourceUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewLineSource("udp", ComponentSettings{
+		"port":            "0",
+		"framing":         "delimiter",
+		"delimiter":       `\t`,
+		"max_line_length": "8",
+	}).(*LineSource)
+	channel := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(channel)
+	go source.serveUDP(conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// several records to a datagram, and the long one is dropped on its own
+	client.Write([]byte("one\ttoo long record\ttwo"))
+	client.Write([]byte("three\t"))
+
+	bodies := waitForBodies(t, channel, 3)
+	if strings.Join(bodies, ",") != "one,two,three" {
+		t.Errorf("Wrong records %q", bodies)
+	}
+}
@@ -5,15 +5,22 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 func main() {
 	flag.Parse()
 
+	if replayFrom != "" {
+		setConfigLocation()
+		Replay()
+		return
+	}
+
 	SetupConfig()
 
 	sigChannel := make(chan os.Signal, 1)
-	signal.Notify(sigChannel, os.Interrupt, os.Kill)
+	signal.Notify(sigChannel, os.Interrupt, syscall.SIGTERM)
 
 loop:
 	for {
@@ -23,4 +30,6 @@ loop:
 			break loop
 		}
 	}
+
+	StopComponents()
 }
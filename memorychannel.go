@@ -2,43 +2,126 @@ package main
 
 import (
 	"container/list"
+	"context"
 	"sync"
+	"time"
 )
 
 func init() {
 	RegisterChannel("memory", NewMemoryChannel)
 }
 
+// MemoryChannel queues events in memory, newest at the front of the list.
+// Events handed to a sink but not yet confirmed are pending and are never
+// expired or dropped to make room, as ConfirmGet removes them by count.
 type MemoryChannel struct {
-	queue *list.List
-	lock  sync.Mutex
+	retention
+	queue    *list.List
+	lock     sync.Mutex
+	notifier eventNotifier
+	bytes    int
+	pending  int
 }
 
 func NewMemoryChannel(config ComponentSettings) Channel {
 	return &MemoryChannel{
-		queue: list.New(),
+		retention: newRetention("memorychannel", config),
+		queue:     list.New(),
 	}
 }
 
 func (m *MemoryChannel) AddEvent(e Event) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	m.queue.PushFront(e)
-	return nil
+	return m.AddEvents([]Event{e})
 }
 
+// AddEvents adds e subject to max_events and max_bytes.  By default all of
+// e is added or, if that won't fit, none of it; the drop_oldest and
+// drop_newest overflow policies make room or add what fits instead.
 func (m *MemoryChannel) AddEvents(e []Event) error {
 	m.lock.Lock()
-	defer m.lock.Unlock()
+	if !m.fits(len(e), eventsSize(e)) {
+		switch m.overflow {
+		case OVERFLOW_REJECT:
+			m.lock.Unlock()
+			return ErrChannelFull
+		case OVERFLOW_DROP_OLDEST:
+			// keep the newest part of e that fits in an empty channel
+			size := eventsSize(e)
+			for len(e) > 0 && m.exceedsLimits(len(e), size) {
+				size -= e[0].Size()
+				e = e[1:]
+				m.countDropped(1)
+			}
+			for !m.fits(len(e), size) {
+				oldest := m.oldestUnpending()
+				if oldest == nil {
+					break
+				}
+				m.remove(oldest)
+				m.countDropped(1)
+			}
+		}
+		// whatever still doesn't fit, such as when pending events can't be
+		// dropped, is dropped from the new events
+		kept, size := 0, 0
+		for kept < len(e) && m.fits(kept+1, size+e[kept].Size()) {
+			size += e[kept].Size()
+			kept++
+		}
+		m.countDropped(len(e) - kept)
+		e = e[:kept]
+	}
 
 	for _, event := range e {
 		m.queue.PushFront(event)
+		m.bytes += event.Size()
+	}
+	m.lock.Unlock()
+
+	if len(e) > 0 {
+		m.notifier.notify()
 	}
 	return nil
 }
 
+func eventsSize(events []Event) int {
+	size := 0
+	for _, e := range events {
+		size += e.Size()
+	}
+	return size
+}
+
+// fits reports whether count more events of size bytes can be queued
+func (m *MemoryChannel) fits(count int, size int) bool {
+	return (m.maxEvents == 0 || m.queue.Len()+count <= m.maxEvents) &&
+		(m.maxBytes == 0 || int64(m.bytes+size) <= m.maxBytes)
+}
+
+// exceedsLimits reports whether count events of size bytes are more than
+// the channel can hold at all
+func (m *MemoryChannel) exceedsLimits(count int, size int) bool {
+	return (m.maxEvents > 0 && count > m.maxEvents) || (m.maxBytes > 0 && int64(size) > m.maxBytes)
+}
+
+// oldestUnpending returns the oldest event that hasn't been handed to a sink
+func (m *MemoryChannel) oldestUnpending() *list.Element {
+	oldest := m.queue.Back()
+	for i := 0; i < m.pending && oldest != nil; i++ {
+		oldest = oldest.Prev()
+	}
+	return oldest
+}
+
+func (m *MemoryChannel) remove(element *list.Element) {
+	m.bytes -= element.Value.(Event).Size()
+	m.queue.Remove(element)
+}
+
 func (m *MemoryChannel) GetOldest(count int) (int, []Event, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	numToGet := IntMin(m.queue.Len(), count)
 	events := make([]Event, 0, numToGet)
 	back := m.queue.Back()
@@ -46,14 +129,19 @@ func (m *MemoryChannel) GetOldest(count int) (int, []Event, error) {
 		events = append(events, back.Value.(Event))
 		back = back.Prev()
 	}
+	m.pending = IntMax(m.pending, numToGet)
 	return numToGet, events, nil
 }
 
 func (m *MemoryChannel) GetAll() (int, []Event, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	events := make([]Event, 0, m.queue.Len())
 	for e := m.queue.Back(); e != nil; e = e.Prev() {
 		events = append(events, e.Value.(Event))
 	}
+	m.pending = len(events)
 	return len(events), events, nil
 }
 
@@ -65,13 +153,54 @@ func (m *MemoryChannel) ConfirmGet(count int) error {
 	back := m.queue.Back()
 	for i := 0; i < numToConfirm; i++ {
 		n := back.Prev()
-		m.queue.Remove(back)
+		m.remove(back)
 		back = n
 	}
+	m.pending = 0
+	return nil
+}
+
+// expire removes events older than max_age, moving them to the dead letter
+// channel if there is one.
+func (m *MemoryChannel) expire(now time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	expired := make([]*list.Element, 0)
+	events := make([]Event, 0)
+	for e := m.oldestUnpending(); e != nil; e = e.Prev() {
+		if event := e.Value.(Event); m.isExpired(event, now) {
+			expired = append(expired, e)
+			events = append(events, event)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	if err := m.sendToDeadLetter(events); err != nil {
+		return err
+	}
+	for _, e := range expired {
+		m.remove(e)
+	}
+	m.countExpired(len(expired))
 	return nil
 }
 
+func (m *MemoryChannel) WaitForEvents(ctx context.Context, timeout time.Duration) bool {
+	return m.notifier.wait(ctx, timeout, m.hasEvents)
+}
+
+func (m *MemoryChannel) hasEvents() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.queue.Len() > 0
+}
+
 func (m *MemoryChannel) Start() error {
+	go m.expireForever(m.expire)
 	return nil
 }
 
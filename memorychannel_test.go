@@ -1,7 +1,9 @@
 package main
 
 import (
+	"strconv"
 	"testing"
+	"time"
 )
 
 func initMemoryChannelTest() (ComponentSettings, Channel) {
@@ -48,9 +50,119 @@ func TestMemoryChannelConfirmGet(t *testing.T) {
 	ChannelConfirmGetTest(memoryChannel, t)
 }
 
+func TestMemoryChannelWaitForEvents(t *testing.T) {
+	c, memoryChannel := initMemoryChannelTest()
+	defer cleanupMemoryChannelTest(c, memoryChannel)
+
+	ChannelWaitForEventsTest(memoryChannel, t)
+}
+
+func TestMemoryChannelMaxEvents(t *testing.T) {
+	c := ComponentSettings{"max_events": "2"}
+	memoryChannel := NewMemoryChannel(c)
+	defer cleanupMemoryChannelTest(c, memoryChannel)
+
+	if err := memoryChannel.AddEvents(makeDummyEvents(3)); err != ErrChannelFull {
+		t.Errorf("Expected ErrChannelFull adding past max_events, got %v", err)
+	}
+	if err := memoryChannel.AddEvents(makeDummyEvents(2)); err != nil {
+		t.Fatalf("Failed to add events: %s", err)
+	}
+	if err := memoryChannel.AddEvent(makeDummyEvents(1)[0]); err != ErrChannelFull {
+		t.Errorf("Expected ErrChannelFull on full channel, got %v", err)
+	}
+}
+
 func TestMemoryChannelStart(t *testing.T) {
 	c, memoryChannel := initMemoryChannelTest()
 	defer cleanupMemoryChannelTest(c, memoryChannel)
 
 	ChannelStartTest(memoryChannel, t)
 }
+
+func TestMemoryChannelDropOldest(t *testing.T) {
+	c := ComponentSettings{"max_events": "3", "overflow": "drop_oldest"}
+	memoryChannel := NewMemoryChannel(c)
+	defer cleanupMemoryChannelTest(c, memoryChannel)
+
+	events := makeDummyEvents(5)
+	memoryChannel.AddEvents(events[:2])
+	// the oldest queued event is pending so it's kept
+	memoryChannel.GetOldest(1)
+	if err := memoryChannel.AddEvents(events[2:4]); err != nil {
+		t.Fatalf("Expected room to be made, got %s", err)
+	}
+	memoryChannel.ConfirmGet(1)
+
+	_, got, _ := memoryChannel.GetAll()
+	if len(got) != 2 || got[0].ID != events[2].ID || got[1].ID != events[3].ID {
+		t.Errorf("Expected the two newest events, got %v", got)
+	}
+	if stats := memoryChannel.(Expirer).Stats(); stats.Dropped != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", stats.Dropped)
+	}
+}
+
+func TestMemoryChannelDropNewest(t *testing.T) {
+	c := ComponentSettings{"max_events": "3", "overflow": "drop_newest"}
+	memoryChannel := NewMemoryChannel(c)
+	defer cleanupMemoryChannelTest(c, memoryChannel)
+
+	events := makeDummyEvents(5)
+	if err := memoryChannel.AddEvents(events); err != nil {
+		t.Fatalf("Expected what fits to be added, got %s", err)
+	}
+	_, got, _ := memoryChannel.GetAll()
+	if len(got) != 3 || got[2].ID != events[2].ID {
+		t.Errorf("Expected the first three events, got %v", got)
+	}
+	if stats := memoryChannel.(Expirer).Stats(); stats.Dropped != 2 {
+		t.Errorf("Expected 2 dropped events, got %d", stats.Dropped)
+	}
+}
+
+func TestMemoryChannelMaxBytes(t *testing.T) {
+	events := makeDummyEvents(2)
+	c := ComponentSettings{"max_bytes": strconv.Itoa(events[0].Size() + 1)}
+	memoryChannel := NewMemoryChannel(c)
+	defer cleanupMemoryChannelTest(c, memoryChannel)
+
+	if err := memoryChannel.AddEvent(events[0]); err != nil {
+		t.Fatalf("Failed to add event: %s", err)
+	}
+	if err := memoryChannel.AddEvent(events[1]); err != ErrChannelFull {
+		t.Errorf("Expected ErrChannelFull adding past max_bytes, got %v", err)
+	}
+}
+
+func TestMemoryChannelExpire(t *testing.T) {
+	c := ComponentSettings{"name": "memory", "max_age": "1h"}
+	memoryChannel := NewMemoryChannel(c).(*MemoryChannel)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	memoryChannel.SetDeadLetter(deadLetter)
+
+	now := time.Now()
+	events := makeDummyEvents(3)
+	events[0].IngestTime = now.Add(-2 * time.Hour)
+	events[1].IngestTime = time.Time{}
+	memoryChannel.AddEvents(events)
+
+	if err := memoryChannel.expire(now); err != nil {
+		t.Fatalf("Failed to expire events: %s", err)
+	}
+	if n, _, _ := memoryChannel.GetAll(); n != 2 {
+		t.Errorf("Expected 2 events to remain, got %d", n)
+	}
+	if stats := memoryChannel.Stats(); stats.Expired != 1 {
+		t.Errorf("Expected 1 expired event, got %d", stats.Expired)
+	}
+
+	n, expired, _ := deadLetter.GetAll()
+	if n != 1 || expired[0].ID != events[0].ID {
+		t.Fatalf("Expected the expired event in the dead letter channel, got %v", expired)
+	}
+	checkHeaders(t, expired[0], map[string]string{"DeadLetterReason": "expired", "DeadLetterChannel": "memory"})
+	if _, ok := events[0].Headers["DeadLetterReason"]; ok {
+		t.Error("Expected the original event's headers to be left alone")
+	}
+}
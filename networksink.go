@@ -2,7 +2,9 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -14,6 +16,7 @@ func init() {
 }
 
 type GobSink struct {
+	*deliveries
 	channel Channel
 	encBuf  *bufio.Writer
 	enc     *gob.Encoder
@@ -33,7 +36,7 @@ func NewGobSink(config ComponentSettings) Sink {
 		log.Fatal("must configure port for gob sink")
 	}
 
-	gs := &GobSink{}
+	gs := &GobSink{deliveries: newDeliveries("gobsink", config)}
 	gs.host = host
 	gs.port = fmt.Sprintf(":%s", port)
 
@@ -60,13 +63,14 @@ func (gs *GobSink) setupConnection() error {
 }
 
 func (gs *GobSink) Start() error {
+	if gs.channel == nil {
+		return errors.New("gobsink: no channel set")
+	}
 	go gs.loopForever()
 	return nil
 }
 
 func (gs *GobSink) loopForever() {
-	tick := time.Tick(time.Millisecond * 500)
-
 	//	lastFailedConnection := time.Time{}
 	attempt := 0
 
@@ -77,8 +81,8 @@ func (gs *GobSink) loopForever() {
 	}
 
 mainfor:
-	for _ = range tick {
-		if gs.channel == nil {
+	for {
+		if !gs.channel.WaitForEvents(context.Background(), SINK_IDLE_TIMEOUT) {
 			continue
 		}
 		if gs.conn == nil {
@@ -87,6 +91,7 @@ mainfor:
 				//				lastFailedConnection = time.Now()
 				attempt = attempt + 1
 				log.Printf("gobsink: Failed to connect, retries: %d", attempt)
+				time.Sleep(SINK_RETRY_BACKOFF)
 				continue
 			} else {
 				attempt = 0
@@ -96,6 +101,7 @@ mainfor:
 		count, events, err := gs.channel.GetAll()
 		if err != nil {
 			log.Printf("gobsink: Error getting events from channel: %s", err)
+			time.Sleep(SINK_RETRY_BACKOFF)
 			continue
 		}
 
@@ -115,8 +121,12 @@ mainfor:
 		}
 
 		for _, event := range events {
+			if gs.skip(event) {
+				continue
+			}
 			if err = gs.enc.Encode(event); err != nil {
 				log.Printf("gobsink: encode: %s", err)
+				gs.failed(event, err)
 				gs.abortSend()
 				continue mainfor
 			}
@@ -128,6 +138,7 @@ mainfor:
 			continue
 		}
 		gs.channel.ConfirmGet(count)
+		gs.confirmed(events)
 	}
 }
 
@@ -135,6 +146,7 @@ func (gs *GobSink) abortSend() {
 	gs.conn.Close()
 	gs.conn = nil
 	gs.channel.ConfirmGet(0)
+	time.Sleep(SINK_RETRY_BACKOFF)
 }
 
 func (gs *GobSink) SetChannel(channel Channel) error {
@@ -142,6 +154,12 @@ func (gs *GobSink) SetChannel(channel Channel) error {
 	return nil
 }
 
+// isDummyEvent reports whether e is one of the empty events sent to probe
+// the connection, which receivers should discard.
+func isDummyEvent(e Event) bool {
+	return e.Version == 0 && e.ID == "" && len(e.Headers) == 0 && len(e.Body) == 0
+}
+
 func shouldSendDummy(m []Event) bool {
 	if len(m) == 0 {
 		return false
@@ -68,6 +68,16 @@ func (g *GobSource) handleConn(conn net.Conn) {
 			conn.Close()
 			return
 		}
+		if err != nil {
+			log.Printf("gobsource: decoding from %s: %s", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		if isDummyEvent(m) {
+			continue
+		}
+		// senders may be running an older version
+		upgradeEvent(&m)
 		for _, channel := range g.channels {
 			channel.AddEvent(m)
 		}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// eventNotifier lets a channel wake up sinks that are waiting on new events.
+// Waiters grab the current ready channel, which is closed (waking all of
+// them) and replaced the next time events are added.
+type eventNotifier struct {
+	lock  sync.Mutex
+	ready chan struct{}
+}
+
+func (n *eventNotifier) notify() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.ready != nil {
+		close(n.ready)
+		n.ready = nil
+	}
+}
+
+func (n *eventNotifier) readyChan() <-chan struct{} {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.ready == nil {
+		n.ready = make(chan struct{})
+	}
+	return n.ready
+}
+
+// wait blocks until the notifier fires, the timeout elapses or ctx is done.
+// hasEvents is checked after registering as a waiter so that events added
+// between the caller's last read and the wait are never missed.  A timeout
+// <= 0 waits until notified or ctx is done.
+func (n *eventNotifier) wait(ctx context.Context, timeout time.Duration, hasEvents func() bool) bool {
+	ready := n.readyChan()
+	if hasEvents() {
+		return true
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-ready:
+		return true
+	case <-expired:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
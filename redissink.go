@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterSink("redis", NewRedisSink)
+}
+
+// RedisSink writes events to Redis, either pushed onto the end of a list
+// with RPUSH (type list, the default) or added to a stream with XADD (type
+// stream).  The key setting is a template like the file sink's file_name:
+// %Y, %m, %d and %H for the event's time and %{Name} for event headers.
+//
+// List entries are the events formatted by the serializer, json by default,
+// without a trailing newline.  Stream entries have the event's ID, body and
+// headers as fields, and maxlen trims streams to about that many entries.
+//
+// Each batch of up to batch_size events is pipelined, and only taken from
+// the channel once Redis has replied to every command.  Commands failing
+// with errors Redis expects to clear up (LOADING, BUSY, OOM, READONLY and
+// so on) are retried on their own; any other error reply gives up on the
+// event.  A connection lost in the middle of a batch means the whole batch
+// is sent again, so events may be written twice.
+//
+// password (and username, for Redis 6 ACLs) are sent with AUTH and db is
+// picked with SELECT.  tls connects with TLS, verifying the server against
+// the system roots or the certificates in the tls_ca file, unless
+// tls_skip_verify is set.
+type RedisSink struct {
+	*deliveries
+	channel    Channel
+	addr       string
+	username   string
+	password   string
+	db         string
+	tlsConfig  *tls.Config
+	timeout    time.Duration
+	dataType   string
+	key        string
+	maxLen     int
+	batchSize  int
+	serializer Serializer
+	conn       *redisConn
+
+	// cancelled by Stop, which waits for done
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// redisConn speaks enough of the Redis protocol (RESP) to send commands and
+// read their replies
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// redisError is an error reply from Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// retryable reports whether Redis may accept the command later
+func (e redisError) retryable() bool {
+	code := strings.SplitN(string(e), " ", 2)[0]
+	switch code {
+	case "LOADING", "BUSY", "TRYAGAIN", "OOM", "READONLY", "MASTERDOWN", "CLUSTERDOWN", "NOREPLICAS":
+		return true
+	}
+	return false
+}
+
+func NewRedisSink(config ComponentSettings) Sink {
+	host, ok := config["host"]
+	if !ok {
+		log.Fatal("must configure host for redis sink")
+	}
+	port := "6379"
+	if p, ok := config["port"]; ok {
+		port = p
+	}
+
+	r := &RedisSink{
+		deliveries: newDeliveries("redissink", config),
+		addr:       net.JoinHostPort(host, port),
+		username:   config["username"],
+		password:   config["password"],
+		db:         config["db"],
+		timeout:    REDIS_SINK_TIMEOUT,
+		dataType:   "list",
+		key:        "collectord",
+		batchSize:  REDIS_SINK_BATCH,
+	}
+
+	if dataType, ok := config["type"]; ok {
+		if dataType != "list" && dataType != "stream" {
+			log.Fatalf("redissink: invalid type %s", dataType)
+		}
+		r.dataType = dataType
+	}
+
+	if key, ok := config["key"]; ok {
+		r.key = key
+	}
+	if _, err := expandTemplate(r.key, time.Now(), func(string) (string, bool) { return "", true }); err != nil {
+		log.Fatalf("redissink: invalid key %s: %s", r.key, err)
+	}
+
+	serializer := "json"
+	if s, ok := config["serializer"]; ok {
+		serializer = s
+	}
+	r.serializer = NewSerializer(serializer, config)
+
+	if db, ok := config["db"]; ok {
+		if n, err := strconv.Atoi(db); err != nil || n < 0 {
+			log.Fatalf("redissink: invalid db %s", db)
+		}
+	}
+	if maxLen, ok := config["maxlen"]; ok {
+		var err error
+		if r.maxLen, err = strconv.Atoi(maxLen); err != nil || r.maxLen <= 0 {
+			log.Fatalf("redissink: invalid maxlen %s", maxLen)
+		}
+	}
+	if size, ok := config["batch_size"]; ok {
+		var err error
+		if r.batchSize, err = strconv.Atoi(size); err != nil || r.batchSize <= 0 {
+			log.Fatalf("redissink: invalid batch_size %s", size)
+		}
+	}
+	if timeout, ok := config["timeout"]; ok {
+		var err error
+		if r.timeout, err = time.ParseDuration(timeout); err != nil || r.timeout <= 0 {
+			log.Fatalf("redissink: invalid timeout %s", timeout)
+		}
+	}
+
+	if config["tls"] == "true" {
+		r.tlsConfig = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: config["tls_skip_verify"] == "true",
+		}
+		if caFile, ok := config["tls_ca"]; ok {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				log.Fatalf("redissink: reading tls_ca: %s", err)
+			}
+			r.tlsConfig.RootCAs = x509.NewCertPool()
+			if !r.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				log.Fatalf("redissink: no certificates in tls_ca %s", caFile)
+			}
+		}
+	}
+
+	return r
+}
+
+func (r *RedisSink) SetChannel(channel Channel) error {
+	r.channel = channel
+	return nil
+}
+
+func (r *RedisSink) Start() error {
+	if r.channel == nil {
+		return errors.New("redissink: no channel set")
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		r.deliverForever(r.ctx, r.channel, r.batchSize, r.send)
+	}()
+	return nil
+}
+
+// Stop closes the connection once the batch being sent is done
+func (r *RedisSink) Stop() error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+	<-r.done
+	if r.conn != nil {
+		return r.conn.Close()
+	}
+	return nil
+}
+
+// send pipelines a command for each event the sink hasn't given up on,
+// returning the ones to try again.  An error means the connection failed
+// and the whole batch should be retried.
+func (r *RedisSink) send(events []Event) ([]Event, error) {
+	commands := make([][]string, 0, len(events))
+	sent := make([]Event, 0, len(events))
+	for _, event := range events {
+		if r.skip(event) {
+			continue
+		}
+		command, err := r.command(event)
+		if err != nil {
+			if r.failed(event, err) {
+				continue
+			}
+			return nil, err
+		}
+		commands = append(commands, command)
+		sent = append(sent, event)
+	}
+	if len(sent) == 0 {
+		return nil, nil
+	}
+
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return nil, err
+		}
+	}
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+	for _, command := range commands {
+		r.conn.writeCommand(command...)
+	}
+	if err := r.conn.w.Flush(); err != nil {
+		r.disconnect()
+		return nil, err
+	}
+
+	retry := make([]Event, 0)
+	for i := range sent {
+		_, err := r.conn.readReply()
+		if replyErr, ok := err.(redisError); ok {
+			if replyErr.retryable() || !r.rejected(sent[i:i+1], err) {
+				retry = append(retry, sent[i])
+			}
+		} else if err != nil {
+			r.disconnect()
+			return nil, err
+		}
+	}
+	return retry, nil
+}
+
+// command builds the RPUSH or XADD for an event
+func (r *RedisSink) command(event Event) ([]string, error) {
+	key, _ := expandTemplate(r.key, eventTimeOrNow(event, time.Now()), func(header string) (string, bool) {
+		return event.Headers[header], true
+	})
+
+	if r.dataType == "list" {
+		record, err := r.serializer.Serialize(event)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"RPUSH", key, string(bytes.TrimSuffix(record, []byte("\n")))}, nil
+	}
+
+	command := []string{"XADD", key}
+	if r.maxLen > 0 {
+		command = append(command, "MAXLEN", "~", strconv.Itoa(r.maxLen))
+	}
+	command = append(command, "*", "id", event.ID, "body", string(event.Body))
+	for name, value := range event.Headers {
+		command = append(command, name, value)
+	}
+	return command, nil
+}
+
+// connect dials Redis and authenticates, selecting the configured database
+func (r *RedisSink) connect() error {
+	dialer := &net.Dialer{Timeout: r.timeout}
+	var conn net.Conn
+	var err error
+	if r.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.addr, r.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", r.addr)
+	}
+	if err != nil {
+		return err
+	}
+	r.conn = &redisConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	setup := make([][]string, 0)
+	if r.password != "" {
+		if r.username != "" {
+			setup = append(setup, []string{"AUTH", r.username, r.password})
+		} else {
+			setup = append(setup, []string{"AUTH", r.password})
+		}
+	}
+	if r.db != "" {
+		setup = append(setup, []string{"SELECT", r.db})
+	}
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+	for _, command := range setup {
+		r.conn.writeCommand(command...)
+		if err = r.conn.w.Flush(); err == nil {
+			_, err = r.conn.readReply()
+		}
+		if err != nil {
+			r.disconnect()
+			return fmt.Errorf("%s: %s", command[0], err)
+		}
+	}
+	return nil
+}
+
+func (r *RedisSink) disconnect() {
+	r.conn.Close()
+	r.conn = nil
+}
+
+func (r *RedisSink) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
+
+// writeCommand buffers a command as an array of bulk strings
+func (c *redisConn) writeCommand(args ...string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readReply reads one reply.  Error replies are returned as a redisError
+// once the whole reply has been read, so the connection can still be used.
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, size)
+		var replyErr error
+		for i := range items {
+			items[i], err = c.readReply()
+			if _, ok := err.(redisError); ok {
+				replyErr = err
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return items, replyErr
+	}
+	return nil, fmt.Errorf("invalid reply %q", line)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process Redis server understanding AUTH, SELECT, RPUSH
+// and XADD.  fail can return an error reply for a command.
+type fakeRedis struct {
+	listener net.Listener
+	lock     sync.Mutex
+	password string
+	fail     func(command []string) string
+	lists    map[string][]string
+	streams  map[string][]map[string]string
+	commands [][]string
+	conns    int
+}
+
+func newFakeRedis(t *testing.T, password string, tlsConfig *tls.Config) *fakeRedis {
+	var listener net.Listener
+	var err error
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	f := &fakeRedis{
+		listener: listener,
+		password: password,
+		lists:    make(map[string][]string),
+		streams:  make(map[string][]map[string]string),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) settings() ComponentSettings {
+	host, port, _ := net.SplitHostPort(f.listener.Addr().String())
+	return ComponentSettings{"host": host, "port": port}
+}
+
+func (f *fakeRedis) Close() {
+	f.listener.Close()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	f.lock.Lock()
+	f.conns++
+	f.lock.Unlock()
+
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		command, err := readFakeRedisCommand(r)
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		f.commands = append(f.commands, command)
+		reply := f.execute(command, &authed)
+		f.lock.Unlock()
+		io.WriteString(conn, reply)
+	}
+}
+
+func (f *fakeRedis) execute(command []string, authed *bool) string {
+	name := strings.ToUpper(command[0])
+	if name == "AUTH" {
+		if command[len(command)-1] != f.password {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+	if f.fail != nil {
+		if reply := f.fail(command); reply != "" {
+			return "-" + reply + "\r\n"
+		}
+	}
+
+	switch name {
+	case "SELECT":
+		return "+OK\r\n"
+	case "RPUSH":
+		key := command[1]
+		if _, ok := f.streams[key]; ok {
+			return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		}
+		f.lists[key] = append(f.lists[key], command[2:]...)
+		return fmt.Sprintf(":%d\r\n", len(f.lists[key]))
+	case "XADD":
+		key, args := command[1], command[2:]
+		if strings.ToUpper(args[0]) == "MAXLEN" {
+			args = args[3:]
+		}
+		entry := make(map[string]string)
+		for i := 1; i+1 < len(args); i += 2 {
+			entry[args[i]] = args[i+1]
+		}
+		f.streams[key] = append(f.streams[key], entry)
+		id := fmt.Sprintf("%d-0", len(f.streams[key]))
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(id), id)
+	}
+	return "-ERR unknown command '" + command[0] + "'\r\n"
+}
+
+func readFakeRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	command := make([]string, count)
+	for i := range command {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		command[i] = string(data[:size])
+	}
+	return command, nil
+}
+
+func TestRedisSinkList(t *testing.T) {
+	redis := newFakeRedis(t, "secret", nil)
+	defer redis.Close()
+
+	config := redis.settings()
+	config["password"] = "secret"
+	config["db"] = "2"
+	config["key"] = "logs:%{app}:%Y%m%d"
+	sink := NewRedisSink(config).(*RedisSink)
+
+	events := makeDummyEvents(3)
+	for i := range events {
+		events[i].Headers["app"] = "web"
+		events[i].Headers["Timestamp"] = "2026-10-18T14:00:00Z"
+	}
+	events[2].Headers["app"] = "db"
+	if retry, err := sink.send(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event written, got %d to retry: %v", len(retry), err)
+	}
+
+	web := redis.lists["logs:web:20261018"]
+	if len(web) != 2 || len(redis.lists["logs:db:20261018"]) != 1 {
+		t.Fatalf("Wrong lists %v", redis.lists)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(web[1]), &decoded); err != nil || decoded["ID"] != events[1].ID {
+		t.Errorf("Expected json serialized events without a newline, got %q", web[1])
+	}
+	if redis.commands[0][0] != "AUTH" || redis.commands[1][0] != "SELECT" || redis.commands[1][1] != "2" {
+		t.Errorf("Expected AUTH then SELECT, got %v", redis.commands[:2])
+	}
+}
+
+func TestRedisSinkStream(t *testing.T) {
+	redis := newFakeRedis(t, "", nil)
+	defer redis.Close()
+
+	config := redis.settings()
+	config["type"] = "stream"
+	config["key"] = "events"
+	config["maxlen"] = "1000"
+	sink := NewRedisSink(config).(*RedisSink)
+
+	events := makeDummyEvents(2)
+	if retry, err := sink.send(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event written, got %d to retry: %v", len(retry), err)
+	}
+
+	entries := redis.streams["events"]
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 stream entries, got %v", redis.streams)
+	}
+	entry := entries[1]
+	if entry["id"] != events[1].ID || entry["body"] != "Event 1" || entry["num"] != "1" {
+		t.Errorf("Wrong stream entry %v", entry)
+	}
+	if command := strings.Join(redis.commands[0][:6], " "); command != "XADD events MAXLEN ~ 1000 *" {
+		t.Errorf("Wrong command %s", command)
+	}
+}
+
+func TestRedisSinkRetriesAndRejects(t *testing.T) {
+	redis := newFakeRedis(t, "", nil)
+	defer redis.Close()
+
+	// "Event 1" hits a full server once, and "Event 2" goes to a stream key
+	loaded := false
+	redis.fail = func(command []string) string {
+		if command[0] == "RPUSH" && strings.Contains(command[2], "Event 1") && !loaded {
+			loaded = true
+			return "OOM command not allowed when used memory > 'maxmemory'"
+		}
+		return ""
+	}
+	redis.streams["stream"] = nil
+
+	config := redis.settings()
+	config["name"] = "cache"
+	config["key"] = "%{key}"
+	config["serializer"] = "body"
+	sink := NewRedisSink(config).(*RedisSink)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetDeadLetter(deadLetter)
+
+	events := makeDummyEvents(3)
+	for i := range events {
+		events[i].Headers["key"] = "list"
+	}
+	events[2].Headers["key"] = "stream"
+
+	retry, err := sink.send(events)
+	if err != nil || len(retry) != 1 || retry[0].ID != events[1].ID {
+		t.Fatalf("Expected event 1 retried, got %d: %v", len(retry), err)
+	}
+	if retry, err = sink.send(retry); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected the retry to succeed, got %d: %v", len(retry), err)
+	}
+	if list := redis.lists["list"]; len(list) != 2 || list[1] != "Event 1" {
+		t.Errorf("Wrong list %q", list)
+	}
+
+	n, dead, _ := deadLetter.GetAll()
+	if n != 1 || dead[0].ID != events[2].ID {
+		t.Fatalf("Expected event 2 dead lettered, got %d", n)
+	}
+	checkHeaders(t, dead[0], map[string]string{"DeadLetterSink": "cache"})
+	if !strings.HasPrefix(dead[0].Headers["DeadLetterError"], "WRONGTYPE") {
+		t.Errorf("Wrong error %s", dead[0].Headers["DeadLetterError"])
+	}
+}
+
+func TestRedisSinkBadPassword(t *testing.T) {
+	redis := newFakeRedis(t, "secret", nil)
+	defer redis.Close()
+
+	config := redis.settings()
+	config["password"] = "wrong"
+	sink := NewRedisSink(config).(*RedisSink)
+	if _, err := sink.send(makeDummyEvents(1)); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Fatalf("Expected AUTH to fail, got %v", err)
+	}
+	if sink.conn != nil {
+		t.Errorf("Expected the connection to be dropped")
+	}
+}
+
+func TestRedisSinkReconnects(t *testing.T) {
+	redis := newFakeRedis(t, "", nil)
+	defer redis.Close()
+
+	sink := NewRedisSink(redis.settings()).(*RedisSink)
+	sink.send(makeDummyEvents(1))
+	sink.conn.Close()
+
+	if _, err := sink.send(makeDummyEvents(1)); err == nil {
+		t.Fatalf("Expected a closed connection to fail")
+	}
+	if retry, err := sink.send(makeDummyEvents(1)); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected to reconnect, got %v", err)
+	}
+	if redis.conns != 2 || len(redis.lists["collectord"]) != 2 {
+		t.Errorf("Expected 2 connections and 2 events, got %d and %d", redis.conns, len(redis.lists["collectord"]))
+	}
+}
+
+func TestRedisSinkTLS(t *testing.T) {
+	cert, certPEM := makeTestCertificate(t)
+	redis := newFakeRedis(t, "", &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer redis.Close()
+
+	dir, err := ioutil.TempDir("", "redissink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, certPEM, 0600)
+
+	config := redis.settings()
+	config["tls"] = "true"
+	config["tls_ca"] = caFile
+	sink := NewRedisSink(config).(*RedisSink)
+	if retry, err := sink.send(makeDummyEvents(2)); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected events written over TLS, got %v", err)
+	}
+	if len(redis.lists["collectord"]) != 2 {
+		t.Errorf("Wrong lists %v", redis.lists)
+	}
+}
+
+func TestRedisSinkLoop(t *testing.T) {
+	redis := newFakeRedis(t, "", nil)
+	defer redis.Close()
+
+	channel := NewMemoryChannel(ComponentSettings{})
+	channel.AddEvents(makeDummyEvents(5))
+	config := redis.settings()
+	config["batch_size"] = "2"
+	sink := NewRedisSink(config).(*RedisSink)
+	sink.SetChannel(channel)
+	sink.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		redis.lock.Lock()
+		written := len(redis.lists["collectord"])
+		redis.lock.Unlock()
+		if written == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out with %d events written", written)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := sink.Stop(); err != nil {
+		t.Errorf("Failed to stop: %s", err)
+	}
+}
+
+// makeTestCertificate makes a self-signed certificate for 127.0.0.1
+func makeTestCertificate(t *testing.T) (tls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
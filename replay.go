@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+var replayFrom string
+var replayTo string
+
+func init() {
+	flag.StringVar(&replayFrom, "replay-from", "", "Move every event in this (dead letter) channel to the -replay-to channel and exit.  The collector using the channels should be stopped first")
+	flag.StringVar(&replayTo, "replay-to", "", "The channel events are replayed into")
+}
+
+// Replay moves the events in the configured -replay-from channel back into
+// the -replay-to channel, without starting any other components.
+func Replay() {
+	if replayTo == "" {
+		log.Fatal("must set -replay-to with -replay-from")
+	}
+
+	readConfig()
+	createChannels()
+
+	from, ok := channelLookup[replayFrom]
+	if !ok {
+		log.Fatalf("No channel named %s to replay from", replayFrom)
+	}
+	to, ok := channelLookup[replayTo]
+	if !ok {
+		log.Fatalf("No channel named %s to replay to", replayTo)
+	}
+
+	replayed, err := replayEvents(from, to)
+	log.Printf("replayed %d events from %s to %s", replayed, replayFrom, replayTo)
+	if err != nil {
+		log.Fatalf("replay failed: %s", err)
+	}
+}
+
+// replayEvents moves events from one channel to another, removing their
+// dead letter headers, until from is empty.
+func replayEvents(from Channel, to Channel) (int, error) {
+	replayed := 0
+	for {
+		count, events, err := from.GetOldest(REPLAY_BATCH)
+		if err != nil || count == 0 {
+			return replayed, err
+		}
+
+		for i, e := range events {
+			events[i] = stripDeadLetterHeaders(e)
+		}
+		if err := to.AddEvents(events); err != nil {
+			from.ConfirmGet(0)
+			return replayed, err
+		}
+		if err := from.ConfirmGet(count); err != nil {
+			return replayed, err
+		}
+		replayed += count
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// What a channel at max_events or max_bytes does with more events
+const (
+	// fail the add with ErrChannelFull
+	OVERFLOW_REJECT = "reject"
+	// drop the oldest queued events to make room
+	OVERFLOW_DROP_OLDEST = "drop_oldest"
+	// keep what fits and drop the rest of the new events
+	OVERFLOW_DROP_NEWEST = "drop_newest"
+)
+
+// ChannelStats counts events a channel discarded instead of delivering
+type ChannelStats struct {
+	Expired uint64
+	Dropped uint64
+}
+
+// Expirer is implemented by channels that expire events.  Expired events
+// are moved to the channel passed to SetDeadLetter, if any.
+type Expirer interface {
+	DeadLetterer
+	Stats() ChannelStats
+}
+
+// retention holds the expiry and overflow settings shared by the channels:
+// max_age (a duration), max_events and max_bytes (0, the default, for no
+// limit), overflow (reject, drop_oldest or drop_newest) and the dead_letter
+// channel, which is bound by the config loader.
+type retention struct {
+	name       string
+	maxAge     time.Duration
+	maxEvents  int
+	maxBytes   int64
+	overflow   string
+	deadLetter Channel
+	expired    uint64
+	dropped    uint64
+}
+
+func newRetention(component string, config ComponentSettings) retention {
+	r := retention{
+		name:     config["name"],
+		overflow: OVERFLOW_REJECT,
+	}
+
+	if age, ok := config["max_age"]; ok {
+		var err error
+		if r.maxAge, err = time.ParseDuration(age); err != nil || r.maxAge < 0 {
+			log.Fatalf("%s: invalid max_age %s", component, age)
+		}
+	}
+
+	if max, ok := config["max_events"]; ok {
+		var err error
+		if r.maxEvents, err = strconv.Atoi(max); err != nil || r.maxEvents < 0 {
+			log.Fatalf("%s: invalid max_events %s", component, max)
+		}
+	}
+	if max, ok := config["max_bytes"]; ok {
+		var err error
+		if r.maxBytes, err = strconv.ParseInt(max, 10, 64); err != nil || r.maxBytes < 0 {
+			log.Fatalf("%s: invalid max_bytes %s", component, max)
+		}
+	}
+
+	switch overflow := config["overflow"]; overflow {
+	case "", OVERFLOW_REJECT:
+	case OVERFLOW_DROP_OLDEST, OVERFLOW_DROP_NEWEST:
+		r.overflow = overflow
+	default:
+		log.Fatalf("%s: unknown overflow policy %s", component, overflow)
+	}
+
+	return r
+}
+
+func (r *retention) SetDeadLetter(channel Channel) {
+	r.deadLetter = channel
+}
+
+func (r *retention) Stats() ChannelStats {
+	return ChannelStats{
+		Expired: atomic.LoadUint64(&r.expired),
+		Dropped: atomic.LoadUint64(&r.dropped),
+	}
+}
+
+func (r *retention) countExpired(n int) {
+	atomic.AddUint64(&r.expired, uint64(n))
+}
+
+func (r *retention) countDropped(n int) {
+	atomic.AddUint64(&r.dropped, uint64(n))
+}
+
+// isExpired reports whether e was ingested more than max_age before now.
+// Events without an ingest time never expire.
+func (r *retention) isExpired(e Event, now time.Time) bool {
+	return r.maxAge > 0 && !e.IngestTime.IsZero() && now.Sub(e.IngestTime) > r.maxAge
+}
+
+// sendToDeadLetter adds expired events to the dead letter channel, marked
+// with the reason and the channel they expired from.
+func (r *retention) sendToDeadLetter(events []Event) error {
+	if r.deadLetter == nil || len(events) == 0 {
+		return nil
+	}
+
+	marked := make([]Event, len(events))
+	for i, e := range events {
+		marked[i] = e.Clone()
+		marked[i].Headers[DEAD_LETTER_REASON] = "expired"
+		marked[i].Headers[DEAD_LETTER_CHANNEL] = r.name
+	}
+	return r.deadLetter.AddEvents(marked)
+}
+
+// expireForever calls expire often enough that events are removed soon
+// after reaching max_age.
+func (r *retention) expireForever(expire func(time.Time) error) {
+	if r.maxAge <= 0 {
+		return
+	}
+
+	interval := CHANNEL_EXPIRE_INTERVAL
+	if r.maxAge/2 < interval {
+		interval = r.maxAge / 2
+	}
+	for now := range time.Tick(interval) {
+		if err := expire(now); err != nil {
+			log.Printf("%s: expiring events: %s", r.name, err)
+		}
+	}
+}
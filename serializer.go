@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+func init() {
+	RegisterSerializer("legacy_tsv", newLegacyTSVSerializer)
+	RegisterSerializer("body", func(ComponentSettings) Serializer { return bodySerializer{} })
+	RegisterSerializer("json", func(ComponentSettings) Serializer { return jsonSerializer{} })
+	RegisterSerializer("tsv", func(config ComponentSettings) Serializer { return newColumnSerializer("tsv", config) })
+	RegisterSerializer("csv", func(config ComponentSettings) Serializer { return newColumnSerializer("csv", config) })
+	RegisterSerializer("avro", newAvroSerializer)
+}
+
+// Serializer formats events for sinks that write them out for people or
+// other programs, such as the file, console and tcp sinks, which pick one
+// with their serializer setting.  Unlike an EventCodec the output doesn't
+// have to round trip.
+type Serializer interface {
+	// Begin returns what goes at the start of every file or connection, or
+	// nil
+	Begin() []byte
+	// Serialize formats one event, including any record separator
+	Serialize(Event) ([]byte, error)
+}
+
+// lineSerializers end every record with a newline and escape any others,
+// so a partly written file can be cut back to its last whole record.  A body
+// serializer record may hold more newlines, so a cut one can't be told
+// apart and body files are quarantined instead.
+var lineSerializers = map[string]bool{
+	"legacy_tsv": true,
+	"json":       true,
+	"tsv":        true,
+}
+
+// Global serializer registry
+
+var registeredSerializers map[string]func(ComponentSettings) Serializer = make(map[string]func(ComponentSettings) Serializer)
+
+func RegisterSerializer(name string, constructor func(ComponentSettings) Serializer) {
+	registeredSerializers[name] = constructor
+}
+
+// NewSerializer creates a serializer, which reads its settings from the
+// config of the sink using it.
+func NewSerializer(name string, config ComponentSettings) Serializer {
+	constructor, ok := registeredSerializers[name]
+	if !ok {
+		log.Fatalf("No serializer registered for name [%s]", name)
+	}
+	return constructor(config)
+}
+
+// legacyTSVSerializer writes the five column layout the legacy sink has
+// always written: timestamp, remote address, body, user agent and referrer.
+type legacyTSVSerializer struct {
+	timestampFormat string
+}
+
+func newLegacyTSVSerializer(config ComponentSettings) Serializer {
+	// the legacy layout has always had unix seconds
+	return legacyTSVSerializer{timestampFormatSetting(config, TIMESTAMP_UNIX)}
+}
+
+func (l legacyTSVSerializer) Begin() []byte {
+	return nil
+}
+
+func (l legacyTSVSerializer) Serialize(event Event) ([]byte, error) {
+	ts := event.Headers["Timestamp"]
+	if t, ok := EventTime(event); ok {
+		ts = FormatTimestamp(t, l.timestampFormat)
+	}
+	return []byte(fmt.Sprintf("%s\t%s\t%s\t%s\t%s\n",
+		ts,
+		event.Headers["RemoteAddr"],
+		string(event.Body),
+		event.Headers["UserAgent"],
+		event.Headers["Referrer"])), nil
+}
+
+// bodySerializer writes each event's body on its own line
+type bodySerializer struct{}
+
+func (bodySerializer) Begin() []byte {
+	return nil
+}
+
+func (bodySerializer) Serialize(event Event) ([]byte, error) {
+	line := make([]byte, 0, len(event.Body)+1)
+	line = append(line, event.Body...)
+	return append(line, '\n'), nil
+}
+
+// jsonSerializer writes whole events as JSON lines
+type jsonSerializer struct{}
+
+func (jsonSerializer) Begin() []byte {
+	return nil
+}
+
+func (jsonSerializer) Serialize(event Event) ([]byte, error) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// columnSerializer writes the columns setting, a comma separated list of
+// header names, as tab or comma separated values.  The columns body, id,
+// timestamp (in the timestamp_format setting, unix_ms by default) and
+// ingest_time are taken from the event instead of its headers.  With
+// header_row set to true each file starts with the column names.
+//
+// CSV values are quoted as needed.  TSV values have tabs, newlines,
+// carriage returns and backslashes escaped as \t, \n, \r and \\.
+type columnSerializer struct {
+	csv             bool
+	columns         []string
+	headerRow       bool
+	timestampFormat string
+}
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+func newColumnSerializer(format string, config ComponentSettings) Serializer {
+	c := &columnSerializer{
+		csv:             format == "csv",
+		columns:         splitList(config["columns"]),
+		timestampFormat: timestampFormatSetting(config, TIMESTAMP_UNIX_MS),
+	}
+	if len(c.columns) == 0 {
+		c.columns = []string{"timestamp", "body"}
+	}
+
+	switch config["header_row"] {
+	case "", "false":
+	case "true":
+		c.headerRow = true
+	default:
+		log.Fatalf("%s: invalid header_row %s", format, config["header_row"])
+	}
+
+	return c
+}
+
+func (c *columnSerializer) Begin() []byte {
+	if !c.headerRow {
+		return nil
+	}
+	return c.row(c.columns)
+}
+
+func (c *columnSerializer) Serialize(event Event) ([]byte, error) {
+	values := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		switch column {
+		case "body":
+			values[i] = string(event.Body)
+		case "id":
+			values[i] = event.ID
+		case "timestamp":
+			values[i] = event.Headers["Timestamp"]
+			if t, ok := EventTime(event); ok {
+				values[i] = FormatTimestamp(t, c.timestampFormat)
+			}
+		case "ingest_time":
+			values[i] = FormatTimestamp(event.IngestTime, c.timestampFormat)
+		default:
+			values[i] = event.Headers[column]
+		}
+	}
+	return c.row(values), nil
+}
+
+func (c *columnSerializer) row(values []string) []byte {
+	var buf bytes.Buffer
+	if c.csv {
+		w := csv.NewWriter(&buf)
+		w.Write(values)
+		w.Flush()
+		return buf.Bytes()
+	}
+
+	for i, value := range values {
+		if i > 0 {
+			buf.WriteByte('\t')
+		}
+		buf.WriteString(tsvEscaper.Replace(value))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLegacySerializer(t *testing.T) {
+	e := NewEvent()
+	e.Headers["Timestamp"] = "1414000000000"
+	e.Headers["RemoteAddr"] = "10.0.0.1:1234"
+	e.Headers["UserAgent"] = "curl/7.0"
+	e.Body = []byte("a=1")
+
+	line, err := NewSerializer("legacy_tsv", ComponentSettings{}).Serialize(e)
+	if err != nil || string(line) != "1414000000\t10.0.0.1:1234\ta=1\tcurl/7.0\t\n" {
+		t.Errorf("Wrong legacy line %q: %v", line, err)
+	}
+}
+
+func TestColumnSerializers(t *testing.T) {
+	e := NewEvent()
+	e.Headers["Timestamp"] = "1414000000000"
+	e.Headers["Host"] = "web1"
+	e.Body = []byte("a\tb\n\"c\",d\\")
+
+	config := ComponentSettings{"columns": "timestamp, Host, body, Missing", "header_row": "true", "timestamp_format": "unix"}
+	tsv := NewSerializer("tsv", config)
+	if begin := string(tsv.Begin()); begin != "timestamp\tHost\tbody\tMissing\n" {
+		t.Errorf("Wrong TSV header row %q", begin)
+	}
+	line, err := tsv.Serialize(e)
+	if err != nil || string(line) != "1414000000\tweb1\ta\\tb\\n\"c\",d\\\\\t\n" {
+		t.Errorf("Wrong TSV line %q: %v", line, err)
+	}
+
+	csv := NewSerializer("csv", config)
+	line, err = csv.Serialize(e)
+	if err != nil || string(line) != "1414000000,web1,\"a\tb\n\"\"c\"\",d\\\",\n" {
+		t.Errorf("Wrong CSV line %q: %v", line, err)
+	}
+
+	if begin := NewSerializer("csv", ComponentSettings{}).Begin(); begin != nil {
+		t.Errorf("Expected no header row by default, got %q", begin)
+	}
+}
+
+func TestJSONSerializer(t *testing.T) {
+	e := makeDummyEvents(1)[0]
+	line, err := NewSerializer("json", ComponentSettings{}).Serialize(e)
+	if err != nil || line[len(line)-1] != '\n' {
+		t.Fatalf("Expected a JSON line, got %q: %v", line, err)
+	}
+	var got Event
+	if err := json.Unmarshal(line, &got); err != nil || got.ID != e.ID || string(got.Body) != string(e.Body) {
+		t.Errorf("Wrong event %+v from %q: %v", got, line, err)
+	}
+}
+
+// avroReader reads just enough Avro to check the serializer's output
+type avroReader struct {
+	t    *testing.T
+	data []byte
+}
+
+func (r *avroReader) long() int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.t.Fatalf("Bad avro long at %q", r.data)
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *avroReader) bytes() []byte {
+	n := int(r.long())
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *avroReader) fixed(n int) []byte {
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v
+}
+
+func TestAvroSerializer(t *testing.T) {
+	avro := NewSerializer("avro", ComponentSettings{})
+	e := makeDummyEvents(1)[0]
+	e.IngestTime = time.Unix(1414000000, 5000)
+	block, err := avro.Serialize(e)
+	if err != nil {
+		t.Fatalf("Failed to serialize: %s", err)
+	}
+
+	r := &avroReader{t, append(avro.Begin(), block...)}
+	if magic := string(r.fixed(4)); magic != "Obj\x01" {
+		t.Fatalf("Wrong magic %q", magic)
+	}
+	meta := make(map[string]string)
+	for count := r.long(); count > 0; count = r.long() {
+		for i := int64(0); i < count; i++ {
+			meta[string(r.bytes())] = string(r.bytes())
+		}
+	}
+	if meta["avro.codec"] != "null" || !json.Valid([]byte(meta["avro.schema"])) {
+		t.Errorf("Wrong metadata %q", meta)
+	}
+	sync := r.fixed(16)
+
+	if count := r.long(); count != 1 {
+		t.Fatalf("Expected a block of one event, got %d", count)
+	}
+	if size := r.long(); int(size) != len(r.data)-16 {
+		t.Errorf("Wrong block size %d", size)
+	}
+	if r.long() != EVENT_VERSION || string(r.bytes()) != e.ID || r.long() != 1414000000000005 {
+		t.Error("Wrong version, ID or ingest time")
+	}
+	headers := make(map[string]string)
+	for count := r.long(); count > 0; count = r.long() {
+		for i := int64(0); i < count; i++ {
+			headers[string(r.bytes())] = string(r.bytes())
+		}
+	}
+	if len(headers) != 1 || headers["num"] != "0" {
+		t.Errorf("Wrong headers %v", headers)
+	}
+	if body := string(r.bytes()); body != "Event 0" {
+		t.Errorf("Wrong body %q", body)
+	}
+	if !bytes.Equal(r.fixed(16), sync) || len(r.data) != 0 {
+		t.Error("Expected the block to end with the sync marker")
+	}
+}
+
+func TestLineSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	received := NewMemoryChannel(ComponentSettings{})
+	source := NewLineSource("tcp", ComponentSettings{"port": "0"}).(*LineSource)
+	source.SetChannel(received)
+	go source.serveTCP(listener)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	sink := NewLineSink(ComponentSettings{"host": host, "port": port})
+	channel := NewMemoryChannel(ComponentSettings{})
+	sink.SetChannel(channel)
+	channel.AddEvents(makeDummyEvents(3))
+	if err := sink.Start(); err != nil {
+		t.Fatalf("Failed to start sink: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if n, events, _ := received.GetAll(); n == 3 {
+			for i, e := range events {
+				if string(e.Body) != string(makeDummyEvents(3)[i].Body) {
+					t.Errorf("Wrong body %q", e.Body)
+				}
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for events")
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterSource("spooldir", NewSpoolDirSource)
+}
+
+// SpoolDirSource ingests immutable files dropped into a directory, such as
+// the complete directory of a legacy sink.  Progress through each file is
+// tracked so a crash part way through resumes where it left off, and files
+// are renamed with the completed suffix (or deleted) once all of their
+// events have been added to the channels.
+type SpoolDirSource struct {
+	channels        []Channel
+	dir             string
+	trackerDir      string
+	completedSuffix string
+	deleteCompleted bool
+	wholeFile       bool
+	legacyFormat    bool
+	batchSize       int
+	pollInterval    time.Duration
+	timestampFormat string
+}
+
+func NewSpoolDirSource(config ComponentSettings) Source {
+	dir, ok := config["dir"]
+	if !ok {
+		log.Fatal("must set dir for spooldir source")
+	}
+
+	s := &SpoolDirSource{
+		channels:        make([]Channel, 0),
+		dir:             dir,
+		trackerDir:      path.Join(dir, ".spooltracker"),
+		completedSuffix: ".COMPLETED",
+		batchSize:       100,
+		pollInterval:    time.Second,
+		timestampFormat: stampFormatSetting("spooldirsource", config, TIMESTAMP_UNIX_MS),
+	}
+
+	if trackerDir, ok := config["tracker_dir"]; ok {
+		s.trackerDir = trackerDir
+	}
+	if suffix, ok := config["completed_suffix"]; ok {
+		s.completedSuffix = suffix
+	}
+	if s.completedSuffix == "" {
+		log.Fatal("spooldirsource: completed_suffix can't be empty")
+	}
+	s.deleteCompleted = config["delete_completed"] == "true"
+
+	switch config["mode"] {
+	case "", "line":
+	case "file":
+		s.wholeFile = true
+	default:
+		log.Fatalf("spooldirsource: unknown mode %s", config["mode"])
+	}
+
+	switch config["format"] {
+	case "", "text":
+	case "legacy":
+		s.legacyFormat = true
+	default:
+		log.Fatalf("spooldirsource: unknown format %s", config["format"])
+	}
+	if s.wholeFile && s.legacyFormat {
+		log.Fatal("spooldirsource: legacy format requires line mode")
+	}
+
+	if size, ok := config["batch_size"]; ok {
+		var err error
+		if s.batchSize, err = strconv.Atoi(size); err != nil || s.batchSize <= 0 {
+			log.Fatalf("spooldirsource: invalid batch_size %s", size)
+		}
+	}
+
+	if interval, ok := config["poll_interval"]; ok {
+		var err error
+		if s.pollInterval, err = time.ParseDuration(interval); err != nil {
+			log.Fatalf("spooldirsource: invalid poll_interval: %s", err)
+		}
+	}
+
+	if err := os.MkdirAll(s.trackerDir, 0755); err != nil {
+		log.Fatalf("spooldirsource: creating tracker dir: %s", err)
+	}
+
+	return s
+}
+
+func (s *SpoolDirSource) SetChannel(channel Channel) error {
+	s.channels = append(s.channels, channel)
+	return nil
+}
+
+func (s *SpoolDirSource) Start() error {
+	go s.spoolForever()
+	return nil
+}
+
+func (s *SpoolDirSource) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
+
+func (s *SpoolDirSource) spoolForever() {
+	for {
+		if !s.poll() {
+			time.Sleep(s.pollInterval)
+		}
+	}
+}
+
+// poll ingests every file currently waiting in the spool directory,
+// returning true if any work was done.
+func (s *SpoolDirSource) poll() bool {
+	files, err := s.pendingFiles()
+	if err != nil {
+		log.Printf("spooldirsource: listing %s: %s", s.dir, err)
+		return false
+	}
+
+	for _, name := range files {
+		if err := s.ingestFile(name); err != nil {
+			log.Printf("spooldirsource: ingesting %s: %s", name, err)
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+// pendingFiles lists files that haven't been completed yet, oldest first.
+// Hidden files are skipped so that writers can create files as dot files
+// and rename them into place once they are complete.
+func (s *SpoolDirSource) pendingFiles() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]os.FileInfo, 0)
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, s.completedSuffix) {
+			continue
+		}
+		pending = append(pending, info)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if !pending[i].ModTime().Equal(pending[j].ModTime()) {
+			return pending[i].ModTime().Before(pending[j].ModTime())
+		}
+		return pending[i].Name() < pending[j].Name()
+	})
+
+	names := make([]string, len(pending))
+	for i, info := range pending {
+		names[i] = info.Name()
+	}
+	return names, nil
+}
+
+func (s *SpoolDirSource) ingestFile(name string) error {
+	filename := path.Join(s.dir, name)
+	if s.wholeFile {
+		body, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		if err := s.addEvents([]Event{s.newEvent(filename, body)}); err != nil {
+			return err
+		}
+		return s.completeFile(name)
+	}
+
+	offset, err := s.readProgress(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	events := make([]Event, 0, s.batchSize)
+	consumed := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			consumed += int64(len(line))
+			events = append(events, s.newEvent(filename, bytes.TrimRight(line, "\r\n")))
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(events) == s.batchSize || (err == io.EOF && len(events) > 0) {
+			if err := s.addEvents(events); err != nil {
+				return err
+			}
+			offset += consumed
+			if err := s.writeProgress(name, offset); err != nil {
+				return err
+			}
+			events = events[:0]
+			consumed = 0
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return s.completeFile(name)
+}
+
+func (s *SpoolDirSource) newEvent(filename string, body []byte) Event {
+	e := NewEvent()
+	if s.legacyFormat {
+		parseLegacyLine(body, &e)
+	} else {
+		e.Body = body
+	}
+	stampEvent(&e, time.Now(), s.timestampFormat)
+	e.Headers["File"] = filename
+	return e
+}
+
+// parseLegacyLine reverses LegacyFileSink.writeEvent.  The body isn't escaped
+// by the sink so it may itself contain tabs, which is why the fixed columns
+// are taken from either end of the line.
+func parseLegacyLine(line []byte, e *Event) {
+	fields := bytes.Split(line, []byte{'\t'})
+	if len(fields) < 5 {
+		e.Headers["SpoolError"] = "expected 5 tab separated legacy columns"
+		e.Body = line
+		return
+	}
+
+	n := len(fields)
+	e.Headers["Timestamp"] = string(fields[0])
+	e.Headers["RemoteAddr"] = string(fields[1])
+	e.Body = bytes.Join(fields[2:n-2], []byte{'\t'})
+	e.Headers["UserAgent"] = string(fields[n-2])
+	e.Headers["Referrer"] = string(fields[n-1])
+}
+
+func (s *SpoolDirSource) addEvents(events []Event) error {
+	for _, channel := range s.channels {
+		if err := channel.AddEvents(events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// completeFile removes the tracker before the file itself, so a crash in
+// between reads the file again from the start rather than leaving an offset
+// behind for the next file spooled under the same name.
+func (s *SpoolDirSource) completeFile(name string) error {
+	filename := path.Join(s.dir, name)
+	err := os.Remove(s.trackerFile(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if s.deleteCompleted {
+		err = os.Remove(filename)
+	} else {
+		err = os.Rename(filename, filename+s.completedSuffix)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("spooldirsource: completed %s", filename)
+	return nil
+}
+
+func (s *SpoolDirSource) trackerFile(name string) string {
+	return path.Join(s.trackerDir, name+".offset")
+}
+
+func (s *SpoolDirSource) readProgress(name string) (int64, error) {
+	raw, err := ioutil.ReadFile(s.trackerFile(name))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+}
+
+// writeProgress records offset by writing a temporary file and renaming it
+// into place, so a crash leaves either the old or the new offset.
+func (s *SpoolDirSource) writeProgress(name string, offset int64) error {
+	tracker := s.trackerFile(name)
+	tmp := tracker + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, tracker)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func initSpoolDirSourceTest(t *testing.T, extra ComponentSettings) (string, *SpoolDirSource, Channel) {
+	dir, err := ioutil.TempDir("", "collectord_spool")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	c := ComponentSettings{"dir": dir, "batch_size": "2"}
+	for k, v := range extra {
+		c[k] = v
+	}
+	source := NewSpoolDirSource(c).(*SpoolDirSource)
+	channel := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(channel)
+	return dir, source, channel
+}
+
+func TestSpoolDirSourceLegacyFormat(t *testing.T) {
+	dir, source, channel := initSpoolDirSourceTest(t, ComponentSettings{"format": "legacy"})
+	defer os.RemoveAll(dir)
+
+	contents := "1414000000\t10.0.0.1:1234\ta=1&b=2\tcurl/7.0\thttp://example.com/\n" +
+		"1414000001\t10.0.0.2:1234\twith\ttab\tcurl/7.0\t\n" +
+		"garbage\n"
+	if err := ioutil.WriteFile(path.Join(dir, "1414000000.txt"), []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write spool file: %s", err)
+	}
+
+	source.poll()
+
+	_, events, _ := channel.GetAll()
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	checkHeaders(t, events[0], map[string]string{
+		"Timestamp":  "1414000000000",
+		"RemoteAddr": "10.0.0.1:1234",
+		"UserAgent":  "curl/7.0",
+		"Referrer":   "http://example.com/",
+	})
+	if string(events[0].Body) != "a=1&b=2" {
+		t.Errorf("Wrong body: %q", events[0].Body)
+	}
+	if string(events[1].Body) != "with\ttab" {
+		t.Errorf("Wrong body for event with tabs: %q", events[1].Body)
+	}
+	if events[2].Headers["SpoolError"] == "" || string(events[2].Body) != "garbage" {
+		t.Errorf("Unparseable line not kept: %+v", events[2])
+	}
+
+	if _, err := os.Stat(path.Join(dir, "1414000000.txt.COMPLETED")); err != nil {
+		t.Errorf("File not marked completed: %s", err)
+	}
+}
+
+func TestSpoolDirSourceResume(t *testing.T) {
+	dir, source, channel := initSpoolDirSourceTest(t, nil)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(path.Join(dir, "events"), []byte("one\ntwo\nthree"), 0644); err != nil {
+		t.Fatalf("Failed to write spool file: %s", err)
+	}
+	// as if a previous run crashed after committing the first line
+	if err := source.writeProgress("events", 4); err != nil {
+		t.Fatalf("Failed to write progress: %s", err)
+	}
+
+	source.poll()
+	expectBodies(t, channel, "two", "three")
+
+	if _, err := os.Stat(source.trackerFile("events")); !os.IsNotExist(err) {
+		t.Errorf("Tracker file not removed after completion")
+	}
+}
+
+func TestSpoolDirSourceDeleteCompleted(t *testing.T) {
+	dir, source, channel := initSpoolDirSourceTest(t, ComponentSettings{"delete_completed": "true"})
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(path.Join(dir, "events"), []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatalf("Failed to write spool file: %s", err)
+	}
+
+	source.poll()
+	expectBodies(t, channel, "one", "two")
+
+	for _, filename := range []string{path.Join(dir, "events"), path.Join(dir, "events.COMPLETED"), source.trackerFile("events")} {
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be gone after completion", filename)
+		}
+	}
+}
+
+func TestSpoolDirSourceReusedName(t *testing.T) {
+	dir, source, channel := initSpoolDirSourceTest(t, ComponentSettings{"delete_completed": "true"})
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(path.Join(dir, "events"), []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatalf("Failed to write spool file: %s", err)
+	}
+	source.poll()
+	expectBodies(t, channel, "one", "two")
+
+	// a new file under the same name is read from the start
+	if err := ioutil.WriteFile(path.Join(dir, "events"), []byte("three\nfour\nfive\n"), 0644); err != nil {
+		t.Fatalf("Failed to write spool file: %s", err)
+	}
+	source.poll()
+	expectBodies(t, channel, "three", "four", "five")
+}
@@ -1,21 +1,55 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 func init() {
 	RegisterChannel("sqlite", NewSqliteChannel)
 }
 
+// SqliteChannel persists events as rows of a sqlite database.  Rows are
+// encoded with the configured codec (json by default) and record which codec
+// wrote them, so the codec can be changed without draining the channel
+// first.  Rows from before the codec column existed are json.
+//
+// The database is opened in WAL mode with synchronous=normal unless
+// configured otherwise, and each AddEvents call is written in a single
+// transaction.  max_events and max_bytes (of encoded rows) bound the size
+// of the queue; batches that don't fit are rejected with ErrChannelFull
+// unless the overflow policy drops the oldest or newest events instead.
+// Events older than max_age are deleted, or moved to the dead letter
+// channel, in the background.  Events handed to a sink but not yet
+// confirmed are never expired or deleted to make room.
+//
+// In fanout mode every sink bound to the channel reads through its own
+// named consumer cursor, stored alongside the queue so it survives
+// restarts, and events are only deleted once every consumer has confirmed
+// them.  A slow consumer therefore holds events back for all of them.
 type SqliteChannel struct {
+	retention
 	dbLock          sync.RWMutex
 	db              *sql.DB
+	insertStmt      *sql.Stmt
+	insertBatchStmt *sql.Stmt
 	unconfirmedGets []int
+	notifier        eventNotifier
+	codecName       string
+	codec           EventCodec
+	maxRead         int
+	events          int
+	bytes           int64
+	lastID          int
+	fanout          bool
+	consumers       map[string]*sqliteConsumer
 }
 
 func NewSqliteChannel(config ComponentSettings) Channel {
@@ -24,8 +58,56 @@ func NewSqliteChannel(config ComponentSettings) Channel {
 		log.Fatal("must configure db for sqlite channel")
 	}
 
-	sqliteChannel := &SqliteChannel{}
-	db, err := sql.Open("sqlite3", dbPath)
+	sqliteChannel := &SqliteChannel{
+		retention: newRetention("sqlitechannel", config),
+		codecName: "json",
+		maxRead:   SQLITE_MAX_READ,
+		consumers: make(map[string]*sqliteConsumer),
+	}
+	if codec, ok := config["codec"]; ok {
+		sqliteChannel.codecName = codec
+	}
+	sqliteChannel.codec = NewEventCodec(sqliteChannel.codecName)
+
+	switch config["mode"] {
+	case "", "queue":
+	case "fanout":
+		sqliteChannel.fanout = true
+	default:
+		log.Fatalf("sqlitechannel: unknown mode %s", config["mode"])
+	}
+
+	journalMode := "wal"
+	if mode, ok := config["journal_mode"]; ok {
+		journalMode = strings.ToLower(mode)
+	}
+	switch journalMode {
+	case "wal", "delete", "truncate", "persist", "memory", "off":
+	default:
+		log.Fatalf("sqlitechannel: invalid journal_mode %s", journalMode)
+	}
+
+	synchronous := "normal"
+	if level, ok := config["synchronous"]; ok {
+		synchronous = strings.ToLower(level)
+	}
+	switch synchronous {
+	case "off", "normal", "full", "extra":
+	default:
+		log.Fatalf("sqlitechannel: invalid synchronous %s", synchronous)
+	}
+
+	if max, ok := config["max_read"]; ok {
+		var err error
+		if sqliteChannel.maxRead, err = strconv.Atoi(max); err != nil || sqliteChannel.maxRead <= 0 {
+			log.Fatalf("sqlitechannel: invalid max_read %s", max)
+		}
+	}
+
+	// pragmas in the dsn are applied to every pooled connection
+	dsn := fmt.Sprintf("%s?_journal_mode=%s&_synchronous=%s&_busy_timeout=%d",
+		dbPath, journalMode, synchronous, SQLITE_BUSY_TIMEOUT)
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,115 +125,315 @@ func (s *SqliteChannel) initDb() error {
 	sql := `
 create table if not exists queue (
 id integer primary key autoincrement,
-body BLOB);`
+body BLOB,
+codec TEXT,
+ingest_time INTEGER);
+create table if not exists cursors (
+consumer TEXT primary key,
+position INTEGER);`
 	_, err := s.db.Exec(sql)
 	if err != nil {
 		log.Fatalf("%q: %s\n", err, sql)
 	}
+	if err := s.migrateDb(); err != nil {
+		return err
+	}
+
+	if s.insertStmt, err = s.db.Prepare(insertQuery(1)); err != nil {
+		return err
+	}
+	if s.insertBatchStmt, err = s.db.Prepare(insertQuery(SQLITE_INSERT_BATCH)); err != nil {
+		return err
+	}
+
+	return s.db.QueryRow("select count(*), coalesce(sum(length(body)), 0), coalesce(max(id), 0) from queue").Scan(&s.events, &s.bytes, &s.lastID)
+}
+
+// migrateDb adds columns to queues created before they existed.  Rows from
+// before ingest_time was added never expire.
+func (s *SqliteChannel) migrateDb() error {
+	for _, column := range []string{"codec TEXT", "ingest_time INTEGER"} {
+		name := strings.Fields(column)[0]
+		var exists bool
+		err := s.db.QueryRow("select exists (select 1 from pragma_table_info('queue') where name = ?)", name).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err = s.db.Exec("alter table queue add column " + column); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *SqliteChannel) AddEvent(m Event) error {
-	s.dbLock.Lock()
-	defer s.dbLock.Unlock()
+// insertQuery builds a statement inserting rows events at once
+func insertQuery(rows int) string {
+	values := strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", rows), ", ")
+	return "insert into queue (body, codec, ingest_time) values " + values
+}
 
-	encoded, err := json.Marshal(m)
-	if err != nil {
-		return err
-	}
-	_, err = s.db.Exec("insert into queue (body) values (?)", encoded)
-	return err
+// insertColumns is the number of values per row in insertQuery
+const insertColumns = 3
+
+func (s *SqliteChannel) AddEvent(m Event) error {
+	return s.AddEvents([]Event{m})
 }
 
+// AddEvents writes all of m in one transaction or, if that would take the
+// channel past max_events or max_bytes, none of it.  The drop_oldest and
+// drop_newest overflow policies make room or write what fits instead.
 func (s *SqliteChannel) AddEvents(m []Event) error {
+	if len(m) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, insertColumns*len(m))
+	sizes := make([]int64, len(m))
+	size := int64(0)
+	for i, event := range m {
+		encoded, err := s.codec.Encode(event)
+		if err != nil {
+			return err
+		}
+		var ingestTime interface{}
+		if !event.IngestTime.IsZero() {
+			ingestTime = event.IngestTime.UnixNano()
+		}
+		args = append(args, encoded, s.codecName, ingestTime)
+		sizes[i] = int64(len(encoded))
+		size += sizes[i]
+	}
+
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
 
-	for _, event := range m {
-		encoded, err := json.Marshal(event)
+	// queued rows to delete to make room, and the events dropped doing so
+	var freeEvents int
+	var freeBytes int64
+	dropped := 0
+	if !s.fits(len(m), size) {
+		switch s.overflow {
+		case OVERFLOW_REJECT:
+			return ErrChannelFull
+		case OVERFLOW_DROP_OLDEST:
+			// keep the newest part of m that fits in an empty channel
+			for len(m) > 0 && s.exceedsLimits(len(m), size) {
+				size -= sizes[0]
+				m, sizes, args = m[1:], sizes[1:], args[insertColumns:]
+				dropped++
+			}
+			if s.maxEvents > 0 {
+				freeEvents = s.events + len(m) - s.maxEvents
+			}
+			if s.maxBytes > 0 {
+				freeBytes = s.bytes + size - s.maxBytes
+			}
+		case OVERFLOW_DROP_NEWEST:
+			for len(m) > 0 && !s.fits(len(m), size) {
+				size -= sizes[len(m)-1]
+				m, sizes = m[:len(m)-1], sizes[:len(m)-1]
+				dropped++
+			}
+			args = args[:insertColumns*len(m)]
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if freeEvents > 0 || freeBytes > 0 {
+		deleted, err := s.deleteOldest(tx, freeEvents, freeBytes)
 		if err != nil {
+			tx.Rollback()
 			return err
 		}
-		_, err = s.db.Exec("insert into queue (body) values (?)", encoded)
-		if err != nil {
+		dropped += deleted
+
+		// whatever still doesn't fit, as pending rows can't be deleted, is
+		// dropped from the new events
+		for len(m) > 0 && !s.fits(len(m), size) {
+			size -= sizes[len(m)-1]
+			m, sizes = m[:len(m)-1], sizes[:len(m)-1]
+			dropped++
+		}
+		args = args[:insertColumns*len(m)]
+	}
+
+	var result sql.Result
+	for len(args) > 0 {
+		stmt := s.insertStmt
+		n := insertColumns
+		if len(args) >= insertColumns*SQLITE_INSERT_BATCH {
+			stmt = s.insertBatchStmt
+			n = insertColumns * SQLITE_INSERT_BATCH
+		}
+		if result, err = tx.Stmt(stmt).Exec(args[:n]...); err != nil {
+			tx.Rollback()
 			return err
 		}
+		args = args[n:]
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.countDropped(dropped)
+	if len(m) == 0 {
+		return nil
+	}
+
+	s.events += len(m)
+	s.bytes += size
+	if lastID, err := result.LastInsertId(); err == nil {
+		s.lastID = int(lastID)
 	}
+
+	s.notifier.notify()
 	return nil
 }
 
-func (s *SqliteChannel) GetOldest(count int) (int, []Event, error) {
-	s.dbLock.RLock()
-	defer s.dbLock.RUnlock()
+// fits reports whether count more rows of size bytes can be queued
+func (s *SqliteChannel) fits(count int, size int64) bool {
+	return (s.maxEvents == 0 || s.events+count <= s.maxEvents) &&
+		(s.maxBytes == 0 || s.bytes+size <= s.maxBytes)
+}
 
-	events := make([]Event, 0, count)
-	ids := make([]int, 0, count)
+// exceedsLimits reports whether count rows of size bytes are more than the
+// channel can hold at all
+func (s *SqliteChannel) exceedsLimits(count int, size int64) bool {
+	return (s.maxEvents > 0 && count > s.maxEvents) || (s.maxBytes > 0 && size > s.maxBytes)
+}
 
-	rows, err := s.db.Query("select id, body from queue order by id limit ?", count)
+// deleteOldest deletes the oldest rows that haven't been handed to a sink
+// until at least events rows and size bytes have been freed, returning how
+// many rows were deleted.
+func (s *SqliteChannel) deleteOldest(tx *sql.Tx, events int, size int64) (int, error) {
+	after := s.pendingThrough()
+	rows, err := tx.Query("select id, length(body) from queue where id > ? order by id", after)
 	if err != nil {
-		return 0, []Event{}, err
+		return 0, err
 	}
-	for rows.Next() {
-		var id int
-		var encoded []byte
-		if err := rows.Scan(&id, &encoded); err != nil {
-			return 0, []Event{}, err
+	maxID, count, freed := 0, 0, int64(0)
+	for (count < events || freed < size) && rows.Next() {
+		var length int64
+		if err := rows.Scan(&maxID, &length); err != nil {
+			rows.Close()
+			return 0, err
 		}
-		var m Event
-		err = json.Unmarshal(encoded, &m)
-		if err != nil {
-			return 0, []Event{}, err
+		count++
+		freed += length
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || count == 0 {
+		return 0, err
+	}
+	return count, s.deleteRange(tx, after, maxID)
+}
+
+// pendingThrough returns the id of the newest row handed to a sink but not
+// yet confirmed, or 0 if there is none.  Rows up to it are never expired or
+// deleted to make room: the sink will deliver them, and in queue mode
+// ConfirmGet deletes them itself.
+func (s *SqliteChannel) pendingThrough() int {
+	through := 0
+	if n := len(s.unconfirmedGets); n > 0 {
+		through = s.unconfirmedGets[n-1]
+	}
+	for _, c := range s.consumers {
+		if n := len(c.unconfirmedGets); n > 0 {
+			through = IntMax(through, c.unconfirmedGets[n-1])
 		}
-		events = append(events, m)
-		ids = append(ids, id)
+	}
+	return through
+}
+
+func (s *SqliteChannel) GetOldest(count int) (int, []Event, error) {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	events, ids, err := s.getEvents(0, IntMin(count, s.maxRead))
+	if err != nil {
+		return 0, []Event{}, err
 	}
 	s.unconfirmedGets = append(s.unconfirmedGets, ids...)
 	return len(events), events, nil
 }
 
+// GetAll returns every queued event, up to max_read of them
 func (s *SqliteChannel) GetAll() (int, []Event, error) {
-	s.dbLock.RLock()
-	defer s.dbLock.RUnlock()
-
-	events := make([]Event, 0)
-	ids := make([]int, 0)
+	return s.GetOldest(s.maxRead)
+}
 
-	rows, err := s.db.Query("select id, body from queue order by id")
+// getEvents reads up to limit events queued after the row with id after
+func (s *SqliteChannel) getEvents(after int, limit int) ([]Event, []int, error) {
+	rows, err := s.db.Query("select id, body, codec from queue where id > ? order by id limit ?", after, limit)
 	if err != nil {
-		return 0, []Event{}, err
+		return nil, nil, err
 	}
+	defer rows.Close()
+	return scanEvents(rows)
+}
 
+// scanEvents decodes rows of id, body and codec
+func scanEvents(rows *sql.Rows) ([]Event, []int, error) {
+	events := make([]Event, 0)
+	ids := make([]int, 0)
 	for rows.Next() {
 		var id int
 		var encoded []byte
-		if err := rows.Scan(&id, &encoded); err != nil {
-			return 0, []Event{}, err
+		var codec sql.NullString
+		if err := rows.Scan(&id, &encoded, &codec); err != nil {
+			return nil, nil, err
 		}
-		var m Event
-		err = json.Unmarshal(encoded, &m)
+		m, err := decodeRow(encoded, codec.String, id)
 		if err != nil {
-			return 0, []Event{}, err
+			return nil, nil, err
 		}
 		events = append(events, m)
 		ids = append(ids, id)
 	}
-	s.unconfirmedGets = append(s.unconfirmedGets, ids...)
-	return len(events), events, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return events, ids, nil
 }
 
-func (s *SqliteChannel) ConfirmGet(count int) error {
-	if count == 0 {
-		s.unconfirmedGets = nil
-		return nil
+func decodeRow(encoded []byte, codecName string, id int) (Event, error) {
+	if codecName == "" {
+		codecName = "json"
+	}
+	codec, ok := registeredEventCodecs[codecName]
+	if !ok {
+		return Event{}, fmt.Errorf("sqlitechannel: row encoded with unknown codec %s", codecName)
 	}
 
+	var m Event
+	if err := codec.Decode(encoded, &m); err != nil {
+		return Event{}, err
+	}
+	// rows from before events had IDs get the same one on every read
+	if m.ID == "" && m.Version < EVENT_VERSION {
+		m.ID = storedEventID(m, id)
+	}
+	upgradeEvent(&m)
+	return m, nil
+}
+
+func (s *SqliteChannel) ConfirmGet(count int) error {
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
 
+	if count == 0 || len(s.unconfirmedGets) == 0 {
+		s.unconfirmedGets = nil
+		return nil
+	}
+
 	ix := IntMin(count-1, len(s.unconfirmedGets)-1)
 	max_id := s.unconfirmedGets[ix]
-	_, err := s.db.Exec("delete from queue where id <= ?", max_id)
-	if err != nil {
+	if err := s.inTx(func(tx *sql.Tx) error { return s.deleteThrough(tx, max_id) }); err != nil {
 		return err
 	}
 
@@ -159,10 +441,270 @@ func (s *SqliteChannel) ConfirmGet(count int) error {
 	return nil
 }
 
-func (s *SqliteChannel) Start() error {
+// inTx runs f in a transaction, committing if it succeeds
+func (s *SqliteChannel) inTx(f func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteThrough deletes every event up to and including max_id
+func (s *SqliteChannel) deleteThrough(tx *sql.Tx, max_id int) error {
+	return s.deleteRange(tx, 0, max_id)
+}
+
+// deleteRange deletes the events after the row with id after, up to and
+// including max_id.  The counters are updated before the transaction
+// commits, which is harmless as callers hold dbLock and a failed commit
+// leaves them slightly low.
+func (s *SqliteChannel) deleteRange(tx *sql.Tx, after int, max_id int) error {
+	var events int
+	var size int64
+	err := tx.QueryRow("select count(*), coalesce(sum(length(body)), 0) from queue where id > ? and id <= ?", after, max_id).Scan(&events, &size)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("delete from queue where id > ? and id <= ?", after, max_id); err != nil {
+		return err
+	}
+	s.events -= events
+	s.bytes -= size
 	return nil
 }
 
+// expire deletes rows older than max_age, first moving them to the dead
+// letter channel if there is one.  Rows handed to a sink and not yet
+// confirmed are left for the sink.
+func (s *SqliteChannel) expire(now time.Time) error {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	cutoff := now.Add(-s.maxAge).UnixNano()
+	after := s.pendingThrough()
+	for {
+		expired := 0
+		err := s.inTx(func(tx *sql.Tx) error {
+			maxID := s.lastID
+			if s.deadLetter != nil {
+				events, ids, err := s.getExpired(tx, cutoff, after)
+				if err != nil || len(ids) == 0 {
+					return err
+				}
+				if err := s.sendToDeadLetter(events); err != nil {
+					return err
+				}
+				maxID = ids[len(ids)-1]
+			}
+
+			var size int64
+			err := tx.QueryRow("select count(*), coalesce(sum(length(body)), 0) from queue where ingest_time < ? and id > ? and id <= ?", cutoff, after, maxID).Scan(&expired, &size)
+			if err != nil || expired == 0 {
+				return err
+			}
+			if _, err := tx.Exec("delete from queue where ingest_time < ? and id > ? and id <= ?", cutoff, after, maxID); err != nil {
+				return err
+			}
+			s.events -= expired
+			s.bytes -= size
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.countExpired(expired)
+
+		// dead lettered rows are moved max_read at a time
+		if s.deadLetter == nil || expired < s.maxRead {
+			return nil
+		}
+	}
+}
+
+func (s *SqliteChannel) getExpired(tx *sql.Tx, cutoff int64, after int) ([]Event, []int, error) {
+	rows, err := tx.Query("select id, body, codec from queue where ingest_time < ? and id > ? order by id limit ?", cutoff, after, s.maxRead)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	return scanEvents(rows)
+}
+
+// deleteConsumed deletes events every consumer has confirmed
+func (s *SqliteChannel) deleteConsumed(tx *sql.Tx) error {
+	var position sql.NullInt64
+	if err := tx.QueryRow("select min(position) from cursors").Scan(&position); err != nil {
+		return err
+	}
+	if !position.Valid {
+		return nil
+	}
+	return s.deleteThrough(tx, int(position.Int64))
+}
+
+func (s *SqliteChannel) WaitForEvents(ctx context.Context, timeout time.Duration) bool {
+	return s.notifier.wait(ctx, timeout, s.hasEvents)
+}
+
+func (s *SqliteChannel) hasEvents() bool {
+	s.dbLock.RLock()
+	defer s.dbLock.RUnlock()
+
+	return s.events > 0
+}
+
+// Start begins expiring events and forgets the cursors of consumers that
+// are no longer bound to the channel, so they don't hold events back
+// forever.
+func (s *SqliteChannel) Start() error {
+	go s.expireForever(s.expire)
+
+	if !s.fanout {
+		return nil
+	}
+
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	rows, err := s.db.Query("select consumer from cursors")
+	if err != nil {
+		return err
+	}
+	stale := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := s.consumers[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, name := range stale {
+			log.Printf("sqlitechannel: removing cursor of unbound consumer %s", name)
+			if _, err := tx.Exec("delete from cursors where consumer = ?", name); err != nil {
+				return err
+			}
+		}
+		return s.deleteConsumed(tx)
+	})
+}
+
+// Consumer returns the channel a sink named name should read from.  Outside
+// of fanout mode every sink shares the channel itself.
+func (s *SqliteChannel) Consumer(name string) (Channel, error) {
+	if !s.fanout {
+		return s, nil
+	}
+
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	if _, ok := s.consumers[name]; ok {
+		return nil, fmt.Errorf("consumer %s is already bound to this channel", name)
+	}
+
+	// new consumers start from the oldest retained event
+	c := &sqliteConsumer{SqliteChannel: s, name: name}
+	err := s.db.QueryRow("select position from cursors where consumer = ?", name).Scan(&c.position)
+	if err == sql.ErrNoRows {
+		_, err = s.db.Exec("insert into cursors (consumer, position) values (?, 0)", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.consumers[name] = c
+	return c, nil
+}
+
 func (s *SqliteChannel) ReloadConfig(config ComponentSettings) bool {
 	return true
 }
+
+// sqliteConsumer is a fanout mode SqliteChannel as seen by a single sink.
+// Events are read from after the consumer's committed position, and
+// confirming them moves the position forward.
+type sqliteConsumer struct {
+	*SqliteChannel
+	name            string
+	position        int
+	unconfirmedGets []int
+}
+
+func (c *sqliteConsumer) GetOldest(count int) (int, []Event, error) {
+	c.dbLock.Lock()
+	defer c.dbLock.Unlock()
+
+	events, ids, err := c.getEvents(c.position, IntMin(count, c.maxRead))
+	if err != nil {
+		return 0, []Event{}, err
+	}
+	c.unconfirmedGets = append(c.unconfirmedGets, ids...)
+	return len(events), events, nil
+}
+
+func (c *sqliteConsumer) GetAll() (int, []Event, error) {
+	return c.GetOldest(c.maxRead)
+}
+
+func (c *sqliteConsumer) ConfirmGet(count int) error {
+	c.dbLock.Lock()
+	defer c.dbLock.Unlock()
+
+	if count == 0 || len(c.unconfirmedGets) == 0 {
+		c.unconfirmedGets = nil
+		return nil
+	}
+
+	position := c.unconfirmedGets[IntMin(count-1, len(c.unconfirmedGets)-1)]
+	err := c.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("update cursors set position = ? where consumer = ?", position, c.name); err != nil {
+			return err
+		}
+		return c.deleteConsumed(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.position = position
+	c.unconfirmedGets = nil
+	return nil
+}
+
+func (c *sqliteConsumer) WaitForEvents(ctx context.Context, timeout time.Duration) bool {
+	return c.notifier.wait(ctx, timeout, c.hasEvents)
+}
+
+// hasEvents looks for rows rather than comparing the position with lastID,
+// as expiry and drop_oldest can remove every row a lagging consumer has yet
+// to read.
+func (c *sqliteConsumer) hasEvents() bool {
+	c.dbLock.RLock()
+	defer c.dbLock.RUnlock()
+
+	var found bool
+	if err := c.db.QueryRow("select exists(select 1 from queue where id > ?)", c.position).Scan(&found); err != nil {
+		log.Printf("sqlitechannel: failed to look for events for consumer %s: %s", c.name, err)
+		return false
+	}
+	return found
+}
+
+// Start is a no-op as the channel itself is started by the config loader
+func (c *sqliteConsumer) Start() error {
+	return nil
+}
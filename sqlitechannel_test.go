@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"path"
+	"strconv"
 	"testing"
+	"time"
 )
 
 // TODO: test error conditions
@@ -66,9 +69,394 @@ func TestSqliteChannelConfirmGet(t *testing.T) {
 	ChannelConfirmGetTest(sqliteChannel, t)
 }
 
+func TestSqliteChannelWaitForEvents(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelTest()
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	ChannelWaitForEventsTest(sqliteChannel, t)
+}
+
 func TestSqliteChannelStart(t *testing.T) {
 	c, sqliteChannel := initSqliteChannelTest()
 	defer cleanupSqliteChannelTest(c, sqliteChannel)
 
 	ChannelStartTest(sqliteChannel, t)
 }
+
+func initSqliteChannelSettingsTest(settings ComponentSettings) (ComponentSettings, Channel) {
+	c, sqliteChannel := initSqliteChannelTest()
+	sqliteChannel.(*SqliteChannel).db.Close()
+	for k, v := range settings {
+		c[k] = v
+	}
+	return c, NewSqliteChannel(c)
+}
+
+func TestSqliteChannelWAL(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelTest()
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	var mode string
+	if err := sqliteChannel.(*SqliteChannel).db.QueryRow("pragma journal_mode").Scan(&mode); err != nil {
+		t.Fatalf("Failed to read journal mode: %s", err)
+	}
+	if mode != "wal" {
+		t.Errorf("Expected wal journal mode, got %s", mode)
+	}
+}
+
+func TestSqliteChannelLargeBatch(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelTest()
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	// more than one multi-row insert plus a remainder
+	events := makeDummyEvents(2*SQLITE_INSERT_BATCH + 7)
+	if err := sqliteChannel.AddEvents(events); err != nil {
+		t.Fatalf("Failed to add events: %s", err)
+	}
+
+	n, got, err := sqliteChannel.GetAll()
+	if err != nil || n != len(events) {
+		t.Fatalf("Expected %d events, got %d (%v)", len(events), n, err)
+	}
+	for i := range events {
+		if got[i].ID != events[i].ID {
+			t.Fatalf("Expected event %d to be %s, got %s", i, events[i].ID, got[i].ID)
+		}
+	}
+}
+
+func TestSqliteChannelMaxRead(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"max_read": "3"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	if err := sqliteChannel.AddEvents(makeDummyEvents(5)); err != nil {
+		t.Fatalf("Failed to add events: %s", err)
+	}
+	if n, _, _ := sqliteChannel.GetAll(); n != 3 {
+		t.Errorf("Expected GetAll to return max_read events, got %d", n)
+	}
+	if err := sqliteChannel.ConfirmGet(3); err != nil {
+		t.Fatalf("Failed to confirm: %s", err)
+	}
+	if n, _, _ := sqliteChannel.GetOldest(10); n != 2 {
+		t.Errorf("Expected remaining 2 events, got %d", n)
+	}
+}
+
+func TestSqliteChannelMaxEvents(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"max_events": "2"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	if err := sqliteChannel.AddEvents(makeDummyEvents(3)); err != ErrChannelFull {
+		t.Errorf("Expected ErrChannelFull adding past max_events, got %v", err)
+	}
+	if err := sqliteChannel.AddEvents(makeDummyEvents(2)); err != nil {
+		t.Fatalf("Failed to add events: %s", err)
+	}
+	if err := sqliteChannel.AddEvent(makeDummyEvents(1)[0]); err != ErrChannelFull {
+		t.Errorf("Expected ErrChannelFull on full channel, got %v", err)
+	}
+
+	// the count is restored from the database on restart
+	sqliteChannel.(*SqliteChannel).db.Close()
+	sqliteChannel = NewSqliteChannel(c)
+	if err := sqliteChannel.AddEvent(makeDummyEvents(1)[0]); err != ErrChannelFull {
+		t.Errorf("Expected ErrChannelFull after restart, got %v", err)
+	}
+
+	sqliteChannel.GetOldest(1)
+	if err := sqliteChannel.ConfirmGet(1); err != nil {
+		t.Fatalf("Failed to confirm: %s", err)
+	}
+	if err := sqliteChannel.AddEvent(makeDummyEvents(1)[0]); err != nil {
+		t.Errorf("Expected room after confirming, got %v", err)
+	}
+}
+
+func TestSqliteChannelMaxBytes(t *testing.T) {
+	events := makeDummyEvents(1)
+	encoded, _ := NewEventCodec("json").Encode(events[0])
+	max := strconv.Itoa(len(encoded) + len(encoded)/2)
+
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"max_bytes": max})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	if err := sqliteChannel.AddEvents(events); err != nil {
+		t.Fatalf("Failed to add event: %s", err)
+	}
+	if err := sqliteChannel.AddEvents(events); err != ErrChannelFull {
+		t.Errorf("Expected ErrChannelFull adding past max_bytes, got %v", err)
+	}
+}
+
+func benchmarkSqliteChannelAdd(b *testing.B, settings ComponentSettings, batch bool) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(settings)
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+	events := makeDummyEvents(100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if batch {
+			if err := sqliteChannel.AddEvents(events); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+		for _, e := range events {
+			if err := sqliteChannel.AddEvent(e); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// the journal and sync settings the channel used before WAL support
+var sqliteRollbackSettings = ComponentSettings{"journal_mode": "delete", "synchronous": "full"}
+
+func BenchmarkSqliteChannelAddEventRollback(b *testing.B) {
+	benchmarkSqliteChannelAdd(b, sqliteRollbackSettings, false)
+}
+
+func BenchmarkSqliteChannelAddEventsRollback(b *testing.B) {
+	benchmarkSqliteChannelAdd(b, sqliteRollbackSettings, true)
+}
+
+func BenchmarkSqliteChannelAddEvent(b *testing.B) {
+	benchmarkSqliteChannelAdd(b, ComponentSettings{}, false)
+}
+
+func BenchmarkSqliteChannelAddEvents(b *testing.B) {
+	benchmarkSqliteChannelAdd(b, ComponentSettings{}, true)
+}
+
+func BenchmarkSqliteChannelAddEventsFull(b *testing.B) {
+	benchmarkSqliteChannelAdd(b, ComponentSettings{"synchronous": "full"}, true)
+}
+
+func TestSqliteChannelQueueConsumer(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelTest()
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	consumer, err := sqliteChannel.(ConsumerChannel).Consumer("sink")
+	if err != nil || consumer != sqliteChannel {
+		t.Errorf("Expected queue mode consumers to share the channel, got %v (%v)", consumer, err)
+	}
+}
+
+func TestSqliteChannelFanout(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"mode": "fanout"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	fanout := sqliteChannel.(ConsumerChannel)
+	a, err := fanout.Consumer("a")
+	if err != nil {
+		t.Fatalf("Failed to create consumer: %s", err)
+	}
+	b, _ := fanout.Consumer("b")
+	if _, err := fanout.Consumer("a"); err == nil {
+		t.Error("Expected error binding consumer a twice")
+	}
+	if err := sqliteChannel.Start(); err != nil {
+		t.Fatalf("Failed to start channel: %s", err)
+	}
+
+	if err := sqliteChannel.AddEvents(makeDummyEvents(3)); err != nil {
+		t.Fatalf("Failed to add events: %s", err)
+	}
+	for _, consumer := range []Channel{a, b} {
+		if !consumer.WaitForEvents(context.Background(), time.Millisecond) {
+			t.Error("Expected consumer to have events")
+		}
+		if n, _, _ := consumer.GetAll(); n != 3 {
+			t.Errorf("Expected each consumer to see 3 events, got %d", n)
+		}
+	}
+
+	a.ConfirmGet(3)
+	if a.WaitForEvents(context.Background(), time.Millisecond) {
+		t.Error("Expected no events left for consumer a")
+	}
+	if n, _, _ := b.GetOldest(2); n != 2 {
+		t.Errorf("Expected events retained for consumer b, got %d", n)
+	}
+	b.ConfirmGet(2)
+	if s := sqliteChannel.(*SqliteChannel); s.events != 1 {
+		t.Errorf("Expected 1 event retained for consumer b, got %d", s.events)
+	}
+
+	// cursors survive restarts
+	sqliteChannel.(*SqliteChannel).db.Close()
+	sqliteChannel = NewSqliteChannel(c)
+	fanout = sqliteChannel.(ConsumerChannel)
+	a, _ = fanout.Consumer("a")
+	b, _ = fanout.Consumer("b")
+	sqliteChannel.Start()
+	if n, _, _ := a.GetAll(); n != 0 {
+		t.Errorf("Expected consumer a to have caught up, got %d", n)
+	}
+	n, events, _ := b.GetAll()
+	if n != 1 || events[0].Headers["num"] != "2" {
+		t.Errorf("Expected consumer b to resume at the last event, got %d %v", n, events)
+	}
+}
+
+func TestSqliteChannelFanoutUnboundConsumer(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"mode": "fanout"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	a, _ := sqliteChannel.(ConsumerChannel).Consumer("a")
+	sqliteChannel.(ConsumerChannel).Consumer("removed")
+	sqliteChannel.AddEvents(makeDummyEvents(2))
+	a.GetAll()
+	a.ConfirmGet(2)
+
+	// after a restart without the removed sink its cursor is dropped
+	sqliteChannel.(*SqliteChannel).db.Close()
+	sqliteChannel = NewSqliteChannel(c)
+	sqliteChannel.(ConsumerChannel).Consumer("a")
+	if err := sqliteChannel.Start(); err != nil {
+		t.Fatalf("Failed to start channel: %s", err)
+	}
+	if s := sqliteChannel.(*SqliteChannel); s.events != 0 {
+		t.Errorf("Expected events confirmed by the remaining consumer to be deleted, got %d", s.events)
+	}
+}
+
+func TestSqliteChannelFanoutExpiredLaggingConsumer(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"mode": "fanout", "max_age": "1h"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+	s := sqliteChannel.(*SqliteChannel)
+
+	lagging, _ := s.Consumer("lagging")
+	now := time.Now()
+	events := makeDummyEvents(3)
+	for i := range events {
+		events[i].IngestTime = now.Add(-2 * time.Hour)
+	}
+	sqliteChannel.AddEvents(events)
+
+	if err := s.expire(now); err != nil {
+		t.Fatalf("Failed to expire events: %s", err)
+	}
+	if n, _, _ := lagging.GetAll(); n != 0 {
+		t.Fatalf("Expected every event to have expired, got %d", n)
+	}
+	lagging.ConfirmGet(0)
+	if lagging.WaitForEvents(context.Background(), time.Millisecond) {
+		t.Error("Expected no events for a consumer whose events all expired")
+	}
+}
+
+func TestSqliteChannelDropOldest(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"max_events": "3", "overflow": "drop_oldest"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	events := makeDummyEvents(6)
+	sqliteChannel.AddEvents(events[:2])
+	if err := sqliteChannel.AddEvents(events[2:]); err != nil {
+		t.Fatalf("Expected room to be made, got %s", err)
+	}
+
+	_, got, _ := sqliteChannel.GetAll()
+	if len(got) != 3 || got[0].ID != events[3].ID || got[2].ID != events[5].ID {
+		t.Errorf("Expected the three newest events, got %v", got)
+	}
+	if stats := sqliteChannel.(Expirer).Stats(); stats.Dropped != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", stats.Dropped)
+	}
+}
+
+func TestSqliteChannelDropNewest(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"max_events": "3", "overflow": "drop_newest"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+
+	events := makeDummyEvents(5)
+	sqliteChannel.AddEvents(events[:2])
+	if err := sqliteChannel.AddEvents(events[2:]); err != nil {
+		t.Fatalf("Expected what fits to be added, got %s", err)
+	}
+
+	_, got, _ := sqliteChannel.GetAll()
+	if len(got) != 3 || got[2].ID != events[2].ID {
+		t.Errorf("Expected the first three events, got %v", got)
+	}
+	if stats := sqliteChannel.(Expirer).Stats(); stats.Dropped != 2 {
+		t.Errorf("Expected 2 dropped events, got %d", stats.Dropped)
+	}
+}
+
+func TestSqliteChannelExpire(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"name": "sqlite", "max_age": "1h"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+	s := sqliteChannel.(*SqliteChannel)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	s.SetDeadLetter(deadLetter)
+
+	now := time.Now()
+	events := makeDummyEvents(3)
+	events[0].IngestTime = now.Add(-2 * time.Hour)
+	events[1].IngestTime = time.Time{}
+	sqliteChannel.AddEvents(events)
+
+	if err := s.expire(now); err != nil {
+		t.Fatalf("Failed to expire events: %s", err)
+	}
+	if n, _, _ := sqliteChannel.GetAll(); n != 2 || s.events != 2 {
+		t.Errorf("Expected 2 events to remain, got %d", n)
+	}
+	if stats := s.Stats(); stats.Expired != 1 {
+		t.Errorf("Expected 1 expired event, got %d", stats.Expired)
+	}
+
+	n, expired, _ := deadLetter.GetAll()
+	if n != 1 || expired[0].ID != events[0].ID {
+		t.Fatalf("Expected the expired event in the dead letter channel, got %v", expired)
+	}
+	checkHeaders(t, expired[0], map[string]string{"DeadLetterReason": "expired", "DeadLetterChannel": "sqlite"})
+}
+
+func TestSqliteChannelKeepsPending(t *testing.T) {
+	c, sqliteChannel := initSqliteChannelSettingsTest(ComponentSettings{"max_events": "3", "overflow": "drop_oldest", "max_age": "1h"})
+	defer cleanupSqliteChannelTest(c, sqliteChannel)
+	s := sqliteChannel.(*SqliteChannel)
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	s.SetDeadLetter(deadLetter)
+
+	now := time.Now()
+	events := makeDummyEvents(6)
+	for i := range events {
+		events[i].IngestTime = now.Add(-2 * time.Hour)
+	}
+	sqliteChannel.AddEvents(events[:3])
+	if n, _, _ := sqliteChannel.GetOldest(2); n != 2 {
+		t.Fatalf("Expected 2 events, got %d", n)
+	}
+
+	// only event 2 isn't pending, so it's the only one that can make room
+	if err := sqliteChannel.AddEvents(events[3:5]); err != nil {
+		t.Fatalf("Expected room to be made, got %s", err)
+	}
+	if err := s.expire(now); err != nil {
+		t.Fatalf("Failed to expire events: %s", err)
+	}
+	if n, _, _ := deadLetter.GetAll(); n != 1 {
+		t.Errorf("Expected only the unpending event expired, got %d", n)
+	}
+	if stats := s.Stats(); stats.Dropped != 2 || stats.Expired != 1 {
+		t.Errorf("Expected 2 dropped and 1 expired, got %+v", stats)
+	}
+
+	// the sink's confirm deletes what it was given and nothing else
+	sqliteChannel.ConfirmGet(2)
+	if n, _, _ := sqliteChannel.GetAll(); n != 0 || s.events != 0 {
+		t.Errorf("Expected no events left, got %d", n)
+	}
+	sqliteChannel.ConfirmGet(0)
+	if err := sqliteChannel.AddEvents(events[5:]); err != nil {
+		t.Fatalf("Failed to add events: %s", err)
+	}
+	if n, got, _ := sqliteChannel.GetAll(); n != 1 || got[0].ID != events[5].ID {
+		t.Errorf("Expected event 5 queued, got %d", n)
+	}
+}
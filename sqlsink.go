@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	RegisterSink("sql", NewSqlSink)
+}
+
+// SqlSink inserts events as rows of table in a sqlite (driver sqlite, dsn
+// the database file) or Postgres (driver postgres, dsn a connection string
+// or URL) database.  The table must already exist.
+//
+// The columns setting maps events to columns as a comma separated list of
+// value or value:column, where the column defaults to the value's name.
+// Values are body, id, timestamp (the event's time) and ingest_time, or
+// else the header of that name, which is NULL if the event doesn't have it.
+// The default is id, timestamp, body.  Times are bound as time.Time, so
+// timestamp columns get them natively.
+//
+// Each batch of up to batch_size events is inserted in one transaction with
+// multi-row inserts, and only confirmed in the channel once it commits.  If
+// a batch fails while the database is reachable its rows are inserted one
+// at a time to find the ones it won't take, which count against
+// max_attempts and are retried until they reach it.  Rows rejected because
+// their id column is a duplicate count as inserted, since a batch that
+// committed but was never confirmed is sent again after a restart.
+type SqlSink struct {
+	*deliveries
+	channel   Channel
+	driver    string
+	db        *sql.DB
+	table     string
+	values    []string
+	columns   []string
+	batchSize int
+
+	// cancelled by Stop, which waits for done
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// sqlIdentifier matches the table and column names the sink accepts,
+// optionally qualified with a schema
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// sqlDrivers maps the driver setting to database/sql driver names
+var sqlDrivers = map[string]string{
+	"sqlite":   "sqlite3",
+	"postgres": "postgres",
+}
+
+func NewSqlSink(config ComponentSettings) Sink {
+	driver, ok := sqlDrivers[config["driver"]]
+	if !ok {
+		log.Fatalf("sqlsink: invalid driver %s", config["driver"])
+	}
+	dsn, ok := config["dsn"]
+	if !ok {
+		log.Fatal("must configure dsn for sql sink")
+	}
+	table, ok := config["table"]
+	if !ok {
+		log.Fatal("must configure table for sql sink")
+	}
+	if !sqlIdentifier.MatchString(table) {
+		log.Fatalf("sqlsink: invalid table %s", table)
+	}
+
+	s := &SqlSink{
+		deliveries: newDeliveries("sqlsink", config),
+		driver:     driver,
+		table:      table,
+		batchSize:  SQL_SINK_BATCH,
+	}
+
+	columns := splitList(config["columns"])
+	if len(columns) == 0 {
+		columns = []string{"id", "timestamp", "body"}
+	}
+	for _, mapping := range columns {
+		parts := strings.SplitN(mapping, ":", 2)
+		value, column := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[0])
+		if len(parts) == 2 {
+			column = strings.TrimSpace(parts[1])
+		}
+		if value == "" || !sqlIdentifier.MatchString(column) || strings.Contains(column, ".") {
+			log.Fatalf("sqlsink: invalid column %s", mapping)
+		}
+		s.values = append(s.values, value)
+		s.columns = append(s.columns, column)
+	}
+
+	if size, ok := config["batch_size"]; ok {
+		var err error
+		if s.batchSize, err = strconv.Atoi(size); err != nil || s.batchSize <= 0 {
+			log.Fatalf("sqlsink: invalid batch_size %s", size)
+		}
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		log.Fatalf("sqlsink: %s", err)
+	}
+	s.db = db
+	return s
+}
+
+func (s *SqlSink) SetChannel(channel Channel) error {
+	s.channel = channel
+	return nil
+}
+
+func (s *SqlSink) Start() error {
+	if s.channel == nil {
+		return errors.New("sqlsink: no channel set")
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		s.deliverForever(s.ctx, s.channel, s.batchSize, s.send)
+	}()
+	return nil
+}
+
+// Stop closes the database once the batch being inserted is done
+func (s *SqlSink) Stop() error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	<-s.done
+	return s.db.Close()
+}
+
+// send inserts the events the sink hasn't given up on, returning the ones
+// to try again.  An error means the database couldn't be reached or was
+// busy with other writers, and the whole batch should be sent again.
+func (s *SqlSink) send(events []Event) ([]Event, error) {
+	rows := make([]Event, 0, len(events))
+	for _, event := range events {
+		if !s.skip(event) {
+			rows = append(rows, event)
+		}
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	err := s.insert(rows)
+	if err == nil {
+		return nil, nil
+	}
+	if transient(err) {
+		return nil, err
+	}
+	if pingErr := s.db.Ping(); pingErr != nil {
+		return nil, err
+	}
+
+	// the database is up, so find the rows it won't take
+	retry := make([]Event, 0)
+	for _, row := range rows {
+		err := s.insert([]Event{row})
+		if err == nil || s.duplicateID(err) {
+			continue
+		}
+		if transient(err) {
+			return nil, err
+		}
+		if !s.failed(row, err) {
+			retry = append(retry, row)
+		}
+	}
+	return retry, nil
+}
+
+// transient reports whether err is down to contention with other writers
+// rather than anything wrong with the rows, so trying again should work
+func transient(err error) bool {
+	switch err := err.(type) {
+	case sqlite3.Error:
+		return err.Code == sqlite3.ErrBusy || err.Code == sqlite3.ErrLocked
+	case *pq.Error:
+		// serialization_failure and deadlock_detected
+		return err.Code == "40001" || err.Code == "40P01"
+	}
+	return false
+}
+
+// duplicateID reports whether err is a unique constraint violation on the
+// column holding event IDs, meaning the row is already there
+func (s *SqlSink) duplicateID(err error) bool {
+	column := ""
+	for i, value := range s.values {
+		if value == "id" {
+			column = s.columns[i]
+		}
+	}
+	if column == "" {
+		return false
+	}
+
+	switch err := err.(type) {
+	case sqlite3.Error:
+		if err.ExtendedCode != sqlite3.ErrConstraintUnique && err.ExtendedCode != sqlite3.ErrConstraintPrimaryKey {
+			return false
+		}
+		// "UNIQUE constraint failed: logs.id"
+		failed := err.Error()[strings.LastIndex(err.Error(), ": ")+2:]
+		return failed == column || strings.HasSuffix(failed, "."+column)
+	case *pq.Error:
+		return err.Code == "23505" && strings.HasPrefix(err.Detail, "Key ("+column+")=")
+	}
+	return false
+}
+
+// insert writes events in one transaction, SQL_INSERT_ROWS rows per
+// statement
+func (s *SqlSink) insert(events []Event) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for len(events) > 0 {
+		n := IntMin(len(events), SQL_INSERT_ROWS)
+		args := make([]interface{}, 0, n*len(s.columns))
+		for _, event := range events[:n] {
+			args = s.appendRow(args, event)
+		}
+		if _, err := tx.Exec(s.insertQuery(n), args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+		events = events[n:]
+	}
+	return tx.Commit()
+}
+
+// insertQuery builds a statement inserting rows events at once
+func (s *SqlSink) insertQuery(rows int) string {
+	columns := make([]string, len(s.columns))
+	for i, column := range s.columns {
+		columns[i] = quoteSqlIdentifier(column)
+	}
+
+	var query strings.Builder
+	fmt.Fprintf(&query, "insert into %s (%s) values ", quoteSqlIdentifier(s.table), strings.Join(columns, ", "))
+	arg := 0
+	for row := 0; row < rows; row++ {
+		if row > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteByte('(')
+		for i := range s.columns {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			arg++
+			if s.driver == "postgres" {
+				fmt.Fprintf(&query, "$%d", arg)
+			} else {
+				query.WriteByte('?')
+			}
+		}
+		query.WriteByte(')')
+	}
+	return query.String()
+}
+
+// appendRow appends the column values for an event
+func (s *SqlSink) appendRow(args []interface{}, event Event) []interface{} {
+	for _, value := range s.values {
+		switch value {
+		case "body":
+			args = append(args, string(event.Body))
+		case "id":
+			args = append(args, event.ID)
+		case "timestamp":
+			args = append(args, eventTimeOrNow(event, time.Now()))
+		case "ingest_time":
+			args = append(args, event.IngestTime.UTC())
+		default:
+			if header, ok := event.Headers[value]; ok {
+				args = append(args, header)
+			} else {
+				args = append(args, nil)
+			}
+		}
+	}
+	return args
+}
+
+// quoteSqlIdentifier quotes each part of a name matching sqlIdentifier
+func quoteSqlIdentifier(name string) string {
+	return `"` + strings.Replace(name, ".", `"."`, 1) + `"`
+}
+
+func (s *SqlSink) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// initSqlSinkTest makes a sqlite database with a logs table and a sink
+// writing to it
+func initSqlSinkTest(t *testing.T, extra ComponentSettings) (string, *SqlSink) {
+	dir, err := ioutil.TempDir("", "sqlsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsn := path.Join(dir, "events.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`create table logs (
+id text primary key,
+ts timestamp,
+ingested timestamp,
+host text not null,
+app text,
+message text)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := ComponentSettings{
+		"driver":  "sqlite",
+		"dsn":     dsn,
+		"table":   "logs",
+		"columns": "id, timestamp:ts, ingest_time:ingested, host, app, body:message",
+	}
+	for name, value := range extra {
+		config[name] = value
+	}
+	return dir, NewSqlSink(config).(*SqlSink)
+}
+
+func TestSqlSinkInsert(t *testing.T) {
+	dir, sink := initSqlSinkTest(t, nil)
+	defer os.RemoveAll(dir)
+	defer sink.db.Close()
+
+	// more than one statement's worth of rows
+	events := makeDummyEvents(SQL_INSERT_ROWS + 50)
+	for i := range events {
+		events[i].Headers["host"] = "web-" + strconv.Itoa(i%3)
+		events[i].Headers["Timestamp"] = "2026-10-18T14:00:00Z"
+	}
+	events[0].Headers["app"] = "nginx"
+	if retry, err := sink.send(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event inserted, got %d to retry: %v", len(retry), err)
+	}
+
+	var count int
+	sink.db.QueryRow("select count(*) from logs").Scan(&count)
+	if count != len(events) {
+		t.Fatalf("Expected %d rows, got %d", len(events), count)
+	}
+
+	var host, message string
+	var app sql.NullString
+	var ts, ingested time.Time
+	err := sink.db.QueryRow("select host, app, message, ts, ingested from logs where id = ?", events[0].ID).
+		Scan(&host, &app, &message, &ts, &ingested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "web-0" || app.String != "nginx" || message != "Event 0" {
+		t.Errorf("Wrong row %s %v %s", host, app, message)
+	}
+	if !ts.Equal(time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)) || !ingested.Equal(events[0].IngestTime) {
+		t.Errorf("Wrong times %s %s", ts, ingested)
+	}
+
+	sink.db.QueryRow("select app from logs where id = ?", events[1].ID).Scan(&app)
+	if app.Valid {
+		t.Errorf("Expected a missing header to be NULL, got %s", app.String)
+	}
+}
+
+func TestSqlSinkBadRow(t *testing.T) {
+	dir, sink := initSqlSinkTest(t, ComponentSettings{"name": "archive", "max_attempts": "2"})
+	defer os.RemoveAll(dir)
+	defer sink.db.Close()
+	deadLetter := NewMemoryChannel(ComponentSettings{})
+	sink.SetDeadLetter(deadLetter)
+
+	// event 1 has no host, which the table requires
+	events := makeDummyEvents(3)
+	events[0].Headers["host"] = "a"
+	events[2].Headers["host"] = "c"
+
+	retry, err := sink.send(events)
+	if err != nil || len(retry) != 1 || retry[0].ID != events[1].ID {
+		t.Fatalf("Expected event 1 retried, got %d: %v", len(retry), err)
+	}
+	if retry, err = sink.send(retry); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected event 1 given up on, got %d: %v", len(retry), err)
+	}
+
+	var count int
+	sink.db.QueryRow("select count(*) from logs").Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected the good rows inserted once, got %d rows", count)
+	}
+	n, dead, _ := deadLetter.GetAll()
+	if n != 1 || dead[0].ID != events[1].ID {
+		t.Fatalf("Expected event 1 dead lettered, got %d", n)
+	}
+	checkHeaders(t, dead[0], map[string]string{"DeadLetterSink": "archive", "DeadLetterAttempts": "2"})
+}
+
+func TestSqlSinkReplayedBatch(t *testing.T) {
+	dir, sink := initSqlSinkTest(t, ComponentSettings{"max_attempts": "1"})
+	defer os.RemoveAll(dir)
+	defer sink.db.Close()
+
+	events := makeDummyEvents(3)
+	for i := range events {
+		events[i].Headers["host"] = "a"
+	}
+	if retry, err := sink.send(events[:2]); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event inserted, got %d to retry: %v", len(retry), err)
+	}
+
+	// the batch committed but wasn't confirmed, so it comes round again with
+	// the next event
+	if retry, err := sink.send(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected the replayed rows to count as inserted, got %d to retry: %v", len(retry), err)
+	}
+	for _, e := range events {
+		if sink.skip(e) {
+			t.Errorf("Expected event %s not to be given up on", e.ID)
+		}
+	}
+	var count int
+	sink.db.QueryRow("select count(*) from logs").Scan(&count)
+	if count != 3 {
+		t.Errorf("Expected each row once, got %d rows", count)
+	}
+}
+
+func TestSqlSinkUnreachable(t *testing.T) {
+	dir, sink := initSqlSinkTest(t, nil)
+	defer os.RemoveAll(dir)
+	sink.db.Close()
+
+	events := makeDummyEvents(1)
+	events[0].Headers["host"] = "a"
+	if _, err := sink.send(events); err == nil {
+		t.Fatal("Expected the batch to fail")
+	}
+	if sink.skip(events[0]) {
+		t.Error("Expected the event not to be given up on")
+	}
+}
+
+func TestSqlSinkLocked(t *testing.T) {
+	dir, sink := initSqlSinkTest(t, ComponentSettings{"max_attempts": "1"})
+	defer os.RemoveAll(dir)
+	dsn := path.Join(dir, "events.db")
+	sink.db.Close()
+	sink.db, _ = sql.Open("sqlite3", dsn+"?_busy_timeout=10")
+	defer sink.db.Close()
+
+	// another writer holds the database
+	other, _ := sql.Open("sqlite3", dsn)
+	defer other.Close()
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("insert into logs (id, host) values ('other', 'b')"); err != nil {
+		t.Fatal(err)
+	}
+
+	events := makeDummyEvents(2)
+	for i := range events {
+		events[i].Headers["host"] = "a"
+	}
+	if _, err := sink.send(events); err == nil {
+		t.Fatal("Expected the batch to fail while the database is locked")
+	}
+	for _, e := range events {
+		if sink.skip(e) {
+			t.Errorf("Expected event %s not to be given up on", e.ID)
+		}
+	}
+
+	tx.Rollback()
+	if retry, err := sink.send(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event inserted once unlocked, got %d to retry: %v", len(retry), err)
+	}
+}
+
+func TestSqlSinkInsertQuery(t *testing.T) {
+	sink := &SqlSink{driver: "postgres", table: "audit.logs", columns: []string{"id", "message"}}
+	expected := `insert into "audit"."logs" ("id", "message") values ($1, $2), ($3, $4)`
+	if query := sink.insertQuery(2); query != expected {
+		t.Errorf("Expected %s, got %s", expected, query)
+	}
+
+	sink.driver = "sqlite3"
+	expected = `insert into "audit"."logs" ("id", "message") values (?, ?)`
+	if query := sink.insertQuery(1); query != expected {
+		t.Errorf("Expected %s, got %s", expected, query)
+	}
+}
+
+func TestSqlSinkLoop(t *testing.T) {
+	dir, sink := initSqlSinkTest(t, ComponentSettings{"batch_size": "2"})
+	defer os.RemoveAll(dir)
+
+	channel := NewMemoryChannel(ComponentSettings{})
+	events := makeDummyEvents(5)
+	for i := range events {
+		events[i].Headers["host"] = "a"
+	}
+	channel.AddEvents(events)
+	sink.SetChannel(channel)
+	sink.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var count int
+		sink.db.QueryRow("select count(*) from logs").Scan(&count)
+		if count == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out with %d rows inserted", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := sink.Stop(); err != nil {
+		t.Errorf("Failed to stop: %s", err)
+	}
+}
+
+// TestSqlSinkPostgres inserts into the database at COLLECTORD_POSTGRES_DSN,
+// if it's set
+func TestSqlSinkPostgres(t *testing.T) {
+	dsn := os.Getenv("COLLECTORD_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("COLLECTORD_POSTGRES_DSN not set")
+	}
+	table := "collectord_test_" + newEventID()[:8]
+	sink := NewSqlSink(ComponentSettings{
+		"driver":  "postgres",
+		"dsn":     dsn,
+		"table":   table,
+		"columns": "id, timestamp:ts, num, body:message",
+	}).(*SqlSink)
+	defer sink.db.Close()
+	if _, err := sink.db.Exec("create table " + table + " (id text primary key, ts timestamptz, num text, message text)"); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.db.Exec("drop table " + table)
+
+	events := makeDummyEvents(SQL_INSERT_ROWS + 1)
+	if retry, err := sink.send(events); err != nil || len(retry) != 0 {
+		t.Fatalf("Expected every event inserted, got %d to retry: %v", len(retry), err)
+	}
+	var message string
+	sink.db.QueryRow("select message from "+table+" where num = $1", "7").Scan(&message)
+	if message != "Event 7" {
+		t.Errorf("Wrong row %q", message)
+	}
+
+	if retry, err := sink.send(events[:2]); err != nil || len(retry) != 0 {
+		t.Errorf("Expected replayed rows to count as inserted, got %d to retry: %v", len(retry), err)
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterSource("syslog", NewSyslogSource)
+}
+
+var (
+	ErrSyslogPriority       = errors.New("missing or invalid priority")
+	ErrSyslogTimestamp      = errors.New("invalid timestamp")
+	ErrSyslogHeader         = errors.New("truncated header")
+	ErrSyslogStructuredData = errors.New("invalid structured data")
+)
+
+// SyslogSource receives RFC 3164 and RFC 5424 messages over UDP, TCP or TLS.
+// Parsed header fields become event headers and the message is the body.
+// Messages that can't be parsed are kept as-is with a SyslogError header.
+type SyslogSource struct {
+	channels        []Channel
+	protocol        string
+	addr            string
+	tlsConfig       *tls.Config
+	maxSize         int
+	timestampFormat string
+}
+
+func NewSyslogSource(config ComponentSettings) Source {
+	port, ok := config["port"]
+	if !ok {
+		log.Fatal("must set port for syslog source")
+	}
+
+	s := &SyslogSource{
+		channels: make([]Channel, 0),
+		protocol: "udp",
+		addr:     fmt.Sprintf("%s:%s", config["host"], port),
+		maxSize:  SYSLOG_MAX_MESSAGE_SIZE,
+
+		timestampFormat: stampFormatSetting("syslogsource", config, TIMESTAMP_UNIX_MS),
+	}
+
+	if protocol, ok := config["protocol"]; ok {
+		s.protocol = protocol
+	}
+	if s.protocol != "udp" && s.protocol != "tcp" {
+		log.Fatalf("syslogsource: unsupported protocol %s", s.protocol)
+	}
+
+	if size, ok := config["max_message_size"]; ok {
+		var err error
+		s.maxSize, err = strconv.Atoi(size)
+		if err != nil || s.maxSize <= 0 {
+			log.Fatalf("syslogsource: invalid max_message_size %s", size)
+		}
+	}
+
+	certFile, hasCert := config["tls_cert"]
+	keyFile, hasKey := config["tls_key"]
+	if hasCert || hasKey {
+		if s.protocol != "tcp" {
+			log.Fatal("syslogsource: tls requires the tcp protocol")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("syslogsource: loading tls certificate: %s", err)
+		}
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	return s
+}
+
+func (s *SyslogSource) SetChannel(channel Channel) error {
+	s.channels = append(s.channels, channel)
+	return nil
+}
+
+func (s *SyslogSource) Start() error {
+	if s.protocol == "udp" {
+		conn, err := net.ListenPacket("udp", s.addr)
+		if err != nil {
+			return err
+		}
+		log.Printf("syslogsource: listening on udp %s", s.addr)
+		go s.serveUDP(conn)
+		return nil
+	}
+
+	var ln net.Listener
+	var err error
+	if s.tlsConfig != nil {
+		ln, err = tls.Listen("tcp", s.addr, s.tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("syslogsource: listening on tcp %s (tls: %t)", s.addr, s.tlsConfig != nil)
+	go s.serveTCP(ln)
+	return nil
+}
+
+func (s *SyslogSource) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, s.maxSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("syslogsource: udp read: %s", err)
+			continue
+		}
+		msg := bytes.TrimRight(buf[:n], "\r\n\x00")
+		if len(msg) == 0 {
+			continue
+		}
+		// buf is reused for the next datagram, the event needs its own copy
+		s.addEvent(newSyslogEvent(append([]byte(nil), msg...), addr.String(), time.Now(), s.timestampFormat))
+	}
+}
+
+func (s *SyslogSource) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("syslogsource: failed to accept connection: %s", err)
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *SyslogSource) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	remote := conn.RemoteAddr().String()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), s.maxSize)
+	scanner.Split(scanSyslogFrames)
+	for scanner.Scan() {
+		msg := scanner.Bytes()
+		if len(msg) == 0 {
+			continue
+		}
+		// the scanner reuses its buffer, the event needs its own copy
+		s.addEvent(newSyslogEvent(append([]byte(nil), msg...), remote, time.Now(), s.timestampFormat))
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("syslogsource: connection from %s: %s", remote, err)
+	}
+}
+
+func (s *SyslogSource) addEvent(e Event) {
+	for _, channel := range s.channels {
+		if err := channel.AddEvent(e); err != nil {
+			log.Printf("syslogsource: add event: %s", err)
+		}
+	}
+}
+
+func (s *SyslogSource) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
+
+// newSyslogEvent builds an event from a single syslog message.  If the
+// message can't be parsed the raw message is kept as the body and the parse
+// error is recorded in the SyslogError header.  The Timestamp is taken from
+// the message where possible, otherwise it's the time the message arrived.
+func newSyslogEvent(msg []byte, remoteAddr string, now time.Time, timestampFormat string) Event {
+	e := NewEvent()
+	if err := parseSyslogMessage(msg, &e, now); err != nil {
+		e = NewEvent()
+		e.Headers["SyslogError"] = err.Error()
+		e.Body = msg
+	}
+	stampEvent(&e, now, timestampFormat)
+	e.Headers["RemoteAddr"] = remoteAddr
+	return e
+}
+
+// parseSyslogMessage fills in e from an RFC 5424 or RFC 3164 message.  The
+// format is picked from the character following the priority: RFC 5424
+// messages always carry a version number there.
+func parseSyslogMessage(msg []byte, e *Event, now time.Time) error {
+	pri, rest, err := parseSyslogPriority(msg)
+	if err != nil {
+		return err
+	}
+	e.SetHeader("Priority", IntValue(int64(pri)))
+	e.SetHeader("Facility", IntValue(int64(pri/8)))
+	e.SetHeader("Severity", IntValue(int64(pri%8)))
+
+	if len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' {
+		if space := bytes.IndexByte(rest, ' '); space > 0 && isDigits(rest[:space]) {
+			return parseRFC5424(rest, e)
+		}
+	}
+	return parseRFC3164(rest, e, now)
+}
+
+func parseSyslogPriority(msg []byte) (int, []byte, error) {
+	if len(msg) < 3 || msg[0] != '<' {
+		return 0, nil, ErrSyslogPriority
+	}
+	end := bytes.IndexByte(msg, '>')
+	if end < 2 || end > 4 || !isDigits(msg[1:end]) {
+		return 0, nil, ErrSyslogPriority
+	}
+	pri, _ := strconv.Atoi(string(msg[1:end]))
+	if pri > 191 {
+		return 0, nil, ErrSyslogPriority
+	}
+	return pri, msg[end+1:], nil
+}
+
+func parseRFC5424(msg []byte, e *Event) error {
+	fields := make([]string, 6)
+	rest := msg
+	for i := range fields {
+		space := bytes.IndexByte(rest, ' ')
+		if space <= 0 {
+			return ErrSyslogHeader
+		}
+		fields[i] = string(rest[:space])
+		rest = rest[space+1:]
+	}
+
+	e.Headers["Version"] = fields[0]
+	if fields[1] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return ErrSyslogTimestamp
+		}
+		SetEventTime(e, ts, TIMESTAMP_RFC3339_NANO)
+	}
+	setSyslogHeader(e, "Hostname", fields[2])
+	setSyslogHeader(e, "AppName", fields[3])
+	setSyslogHeader(e, "ProcID", fields[4])
+	setSyslogHeader(e, "MsgID", fields[5])
+
+	sd, rest, err := parseStructuredData(rest, e)
+	if err != nil {
+		return err
+	}
+	setSyslogHeader(e, "StructuredData", sd)
+
+	if len(rest) > 0 {
+		if rest[0] != ' ' {
+			return ErrSyslogStructuredData
+		}
+		rest = rest[1:]
+	}
+	e.Body = bytes.TrimPrefix(rest, []byte("\xef\xbb\xbf"))
+	return nil
+}
+
+// parseStructuredData consumes the STRUCTURED-DATA part of an RFC 5424
+// message, returning it verbatim along with the remaining message.  Each
+// parameter is also added as an "SD.<sd-id>.<param-name>" header.
+func parseStructuredData(msg []byte, e *Event) (string, []byte, error) {
+	if len(msg) == 0 {
+		return "", nil, ErrSyslogHeader
+	}
+	if msg[0] == '-' {
+		return "-", msg[1:], nil
+	}
+
+	i := 0
+	for i < len(msg) && msg[i] == '[' {
+		i++
+		start := i
+		for i < len(msg) && msg[i] != ' ' && msg[i] != ']' {
+			i++
+		}
+		if i == start || i == len(msg) {
+			return "", nil, ErrSyslogStructuredData
+		}
+		id := string(msg[start:i])
+
+		for i < len(msg) && msg[i] == ' ' {
+			i++
+			start = i
+			for i < len(msg) && msg[i] != '=' {
+				i++
+			}
+			if i+1 >= len(msg) || i == start || msg[i+1] != '"' {
+				return "", nil, ErrSyslogStructuredData
+			}
+			name := string(msg[start:i])
+			i += 2
+
+			var value []byte
+			for i < len(msg) && msg[i] != '"' {
+				if msg[i] == '\\' && i+1 < len(msg) && strings.IndexByte(`"\]`, msg[i+1]) >= 0 {
+					i++
+				}
+				value = append(value, msg[i])
+				i++
+			}
+			if i == len(msg) {
+				return "", nil, ErrSyslogStructuredData
+			}
+			i++
+			e.Headers[fmt.Sprintf("SD.%s.%s", id, name)] = string(value)
+		}
+
+		if i == len(msg) || msg[i] != ']' {
+			return "", nil, ErrSyslogStructuredData
+		}
+		i++
+	}
+	if i == 0 {
+		return "", nil, ErrSyslogStructuredData
+	}
+	return string(msg[:i]), msg[i:], nil
+}
+
+// parseRFC3164 handles the loosely specified BSD format, which is
+// "TIMESTAMP SP HOSTNAME SP TAG[PID]: MSG" following the priority.
+// Senders commonly drop pieces of the header, so anything that doesn't
+// look like the expected part is left in the message rather than rejected.
+func parseRFC3164(msg []byte, e *Event, now time.Time) error {
+	rest := msg
+	if len(rest) >= 16 && rest[15] == ' ' {
+		ts, err := time.ParseInLocation(time.Stamp, string(rest[:15]), now.Location())
+		if err == nil {
+			// BSD timestamps have no year, assume the most recent one
+			ts = ts.AddDate(now.Year(), 0, 0)
+			if ts.After(now.Add(24 * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			SetEventTime(e, ts, TIMESTAMP_RFC3339_NANO)
+			rest = rest[16:]
+
+			if space := bytes.IndexByte(rest, ' '); space > 0 && !isSyslogTag(rest[:space]) {
+				e.Headers["Hostname"] = string(rest[:space])
+				rest = rest[space+1:]
+			}
+		}
+	}
+
+	if end := bytes.IndexAny(rest, ":[ "); end > 0 && end <= 32 {
+		tag := rest[:end]
+		after := rest[end:]
+		var pid []byte
+		if after[0] == '[' {
+			if pidEnd := bytes.IndexByte(after, ']'); pidEnd > 0 {
+				pid = after[1:pidEnd]
+				after = after[pidEnd+1:]
+			}
+		}
+		if len(after) > 0 && after[0] == ':' {
+			e.Headers["AppName"] = string(tag)
+			if len(pid) > 0 {
+				e.Headers["ProcID"] = string(pid)
+			}
+			rest = bytes.TrimPrefix(after[1:], []byte(" "))
+		}
+	}
+
+	e.Body = rest
+	return nil
+}
+
+// isSyslogTag reports whether a header token is a TAG rather than a HOSTNAME,
+// which happens when a sender leaves out the hostname.
+func isSyslogTag(token []byte) bool {
+	return bytes.HasSuffix(token, []byte(":"))
+}
+
+func setSyslogHeader(e *Event, name, value string) {
+	if value != "" && value != "-" {
+		e.Headers[name] = value
+	}
+}
+
+func isDigits(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
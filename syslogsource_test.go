@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var syslogTestNow = time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+
+func checkHeaders(t *testing.T, e Event, expected map[string]string) {
+	for name, value := range expected {
+		if e.Headers[name] != value {
+			t.Errorf("header %s: expected %q, got %q", name, value, e.Headers[name])
+		}
+	}
+}
+
+func TestSyslogRFC5424(t *testing.T) {
+	msg := `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Appl\"ication"][examplePriority@32473 class="high"] An application event`
+	e := newSyslogEvent([]byte(msg), "10.0.0.1:514", syslogTestNow, TIMESTAMP_UNIX)
+
+	checkHeaders(t, e, map[string]string{
+		"Facility":                         "20",
+		"Severity":                         "5",
+		"Version":                          "1",
+		"Timestamp":                        "1065910455",
+		"Hostname":                         "mymachine.example.com",
+		"AppName":                          "evntslog",
+		"MsgID":                            "ID47",
+		"SD.exampleSDID@32473.iut":         "3",
+		"SD.exampleSDID@32473.eventSource": `Appl"ication`,
+		"SD.examplePriority@32473.class":   "high",
+		"RemoteAddr":                       "10.0.0.1:514",
+	})
+	if _, ok := e.Headers["ProcID"]; ok {
+		t.Errorf("nil ProcID should not be set")
+	}
+	if _, ok := e.Headers["SyslogError"]; ok {
+		t.Errorf("unexpected parse error: %s", e.Headers["SyslogError"])
+	}
+	if string(e.Body) != "An application event" {
+		t.Errorf("wrong body: %q", e.Body)
+	}
+}
+
+func TestSyslogRFC5424NoMessage(t *testing.T) {
+	e := newSyslogEvent([]byte("<34>1 - host app 1234 - -"), "", syslogTestNow, TIMESTAMP_UNIX)
+
+	checkHeaders(t, e, map[string]string{
+		"Hostname": "host",
+		"ProcID":   "1234",
+	})
+	if len(e.Body) != 0 {
+		t.Errorf("expected empty body, got %q", e.Body)
+	}
+}
+
+func TestSyslogRFC3164(t *testing.T) {
+	e := newSyslogEvent([]byte("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8"), "", syslogTestNow, TIMESTAMP_UNIX)
+
+	checkHeaders(t, e, map[string]string{
+		"Facility":  "4",
+		"Severity":  "2",
+		"Timestamp": "1791756855",
+		"Hostname":  "mymachine",
+		"AppName":   "su",
+		"ProcID":    "123",
+	})
+	if string(e.Body) != "'su root' failed for lonvick on /dev/pts/8" {
+		t.Errorf("wrong body: %q", e.Body)
+	}
+}
+
+func TestSyslogRFC3164NoHostname(t *testing.T) {
+	e := newSyslogEvent([]byte("<13>Feb  5 17:32:18 sshd: connection closed"), "", syslogTestNow, TIMESTAMP_UNIX)
+
+	if _, ok := e.Headers["Hostname"]; ok {
+		t.Errorf("unexpected hostname %q", e.Headers["Hostname"])
+	}
+	checkHeaders(t, e, map[string]string{"AppName": "sshd"})
+	if string(e.Body) != "connection closed" {
+		t.Errorf("wrong body: %q", e.Body)
+	}
+}
+
+func TestSyslogUnparseable(t *testing.T) {
+	for _, msg := range []string{
+		"no priority here",
+		"<999>Oct 11 22:14:15 host app: msg",
+		"<34>1 not-a-timestamp host app - - - msg",
+		"<34>1 - host app - - [broken msg",
+	} {
+		e := newSyslogEvent([]byte(msg), "10.0.0.1:514", syslogTestNow, TIMESTAMP_UNIX)
+		if e.Headers["SyslogError"] == "" {
+			t.Errorf("expected parse error for %q", msg)
+		}
+		if string(e.Body) != msg {
+			t.Errorf("unparseable message not kept, got %q", e.Body)
+		}
+		checkHeaders(t, e, map[string]string{"RemoteAddr": "10.0.0.1:514"})
+	}
+}
+
+func TestScanSyslogFrames(t *testing.T) {
+	stream := "<13>newline framed\n11 <13>counted<13>also newline\r\n5 <13>a"
+	scanner := bufio.NewScanner(strings.NewReader(stream))
+	scanner.Split(scanSyslogFrames)
+
+	expected := []string{"<13>newline framed", "<13>counted", "<13>also newline", "<13>a"}
+	for i, frame := range expected {
+		if !scanner.Scan() {
+			t.Fatalf("frame %d: scan stopped early: %s", i, scanner.Err())
+		}
+		if scanner.Text() != frame {
+			t.Errorf("frame %d: expected %q, got %q", i, frame, scanner.Text())
+		}
+	}
+	if scanner.Scan() {
+		t.Errorf("unexpected extra frame %q", scanner.Text())
+	}
+}
+
+func TestSyslogUDPBodies(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewSyslogSource(ComponentSettings{"port": "0"}).(*SyslogSource)
+	channel := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(channel)
+	go source.serveUDP(conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	messages := []string{"<34>Oct 11 22:14:15 host app: first message", "<34>Oct 11 22:14:16 host app: second"}
+	for _, msg := range messages {
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var events []Event
+	for len(events) < len(messages) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out with %d of %d messages received", len(events), len(messages))
+		}
+		channel.WaitForEvents(context.Background(), 100*time.Millisecond)
+		n, got, _ := channel.GetAll()
+		channel.ConfirmGet(n)
+		events = append(events, got...)
+	}
+	if string(events[0].Body) != "first message" || string(events[1].Body) != "second" {
+		t.Errorf("Wrong bodies %q %q", events[0].Body, events[1].Body)
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func init() {
+	RegisterSource("tail", NewTailSource)
+}
+
+// TailSource follows files matching a set of glob patterns, turning each line
+// (or multi-line record) into an event.
+//
+// Files are tracked by device and inode rather than by name, so a file that
+// is renamed away during rotation is read to the end before it's dropped and
+// the file that replaces it is picked up from the start.  A file that shrinks
+// below the current offset is assumed to have been truncated in place
+// (copytruncate) and is re-read from the beginning.  Offsets are only
+// persisted once events have been added to the channels, so restarts resume
+// without gaps (and at worst with a re-send of the last uncommitted batch).
+type TailSource struct {
+	channels         []Channel
+	patterns         []string
+	positionsPath    string
+	pollInterval     time.Duration
+	multilineStart   *regexp.Regexp
+	multilineTimeout time.Duration
+	maxLineLength    int
+	host             string
+	timestampFormat  string
+
+	files     map[tailFileKey]*tailedFile
+	positions map[tailFileKey]tailPosition
+	dirty     bool
+}
+
+type tailFileKey struct {
+	Device uint64
+	Inode  uint64
+}
+
+// tailPosition is what gets persisted for each file between runs
+type tailPosition struct {
+	Path   string `json:"path"`
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+type tailedFile struct {
+	key    tailFileKey
+	path   string
+	file   *os.File
+	reader *bufio.Reader
+	// seen is set when the file matched on the most recent scan
+	seen bool
+
+	// readOffset is the offset of the next byte from reader, committed is
+	// the offset of the first byte that hasn't made it into the channels
+	readOffset int64
+	committed  int64
+	partial    []byte
+
+	record    []byte
+	hasRecord bool
+	lastData  time.Time
+}
+
+// tailRecord is an event along with the offset just past its last byte
+type tailRecord struct {
+	event Event
+	end   int64
+}
+
+func NewTailSource(config ComponentSettings) Source {
+	paths, ok := config["paths"]
+	if !ok {
+		log.Fatal("must set paths for tail source")
+	}
+
+	positionsPath, ok := config["positions"]
+	if !ok {
+		log.Fatal("must set positions file for tail source")
+	}
+
+	t := &TailSource{
+		channels:         make([]Channel, 0),
+		positionsPath:    positionsPath,
+		pollInterval:     time.Second,
+		multilineTimeout: 2 * time.Second,
+		maxLineLength:    TAIL_MAX_LINE_LENGTH,
+		timestampFormat:  stampFormatSetting("tailsource", config, TIMESTAMP_UNIX_MS),
+		files:            make(map[tailFileKey]*tailedFile),
+		positions:        make(map[tailFileKey]tailPosition),
+	}
+
+	for _, pattern := range strings.Split(paths, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Fatalf("tailsource: invalid path pattern %s: %s", pattern, err)
+		}
+		t.patterns = append(t.patterns, pattern)
+	}
+
+	if interval, ok := config["poll_interval"]; ok {
+		var err error
+		if t.pollInterval, err = time.ParseDuration(interval); err != nil {
+			log.Fatalf("tailsource: invalid poll_interval: %s", err)
+		}
+	}
+
+	if start, ok := config["multiline_start"]; ok {
+		var err error
+		if t.multilineStart, err = regexp.Compile(start); err != nil {
+			log.Fatalf("tailsource: invalid multiline_start: %s", err)
+		}
+	}
+
+	if timeout, ok := config["multiline_timeout"]; ok {
+		var err error
+		if t.multilineTimeout, err = time.ParseDuration(timeout); err != nil {
+			log.Fatalf("tailsource: invalid multiline_timeout: %s", err)
+		}
+	}
+
+	if length, ok := config["max_line_length"]; ok {
+		var err error
+		if t.maxLineLength, err = strconv.Atoi(length); err != nil || t.maxLineLength <= 0 {
+			log.Fatalf("tailsource: invalid max_line_length %s", length)
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		log.Printf("tailsource: unable to determine hostname: %s", err)
+	}
+	t.host = host
+
+	if err := t.loadPositions(); err != nil {
+		log.Fatalf("tailsource: loading positions: %s", err)
+	}
+
+	return t
+}
+
+func (t *TailSource) SetChannel(channel Channel) error {
+	t.channels = append(t.channels, channel)
+	return nil
+}
+
+func (t *TailSource) Start() error {
+	t.forgetGone()
+	go t.tailForever()
+	return nil
+}
+
+// forgetGone opens the files to follow and drops the positions of files
+// that are gone for good
+func (t *TailSource) forgetGone() {
+	t.scan()
+	for key := range t.positions {
+		if _, ok := t.files[key]; !ok {
+			delete(t.positions, key)
+			t.dirty = true
+		}
+	}
+}
+
+func (t *TailSource) ReloadConfig(config ComponentSettings) bool {
+	return true
+}
+
+func (t *TailSource) tailForever() {
+	for {
+		if !t.poll() {
+			time.Sleep(t.pollInterval)
+		}
+	}
+}
+
+// poll reads whatever is new in the followed files, returning true if there
+// is more data waiting to be read right away.
+func (t *TailSource) poll() bool {
+	t.scan()
+
+	busy := false
+	for _, f := range t.orderedFiles() {
+		more, err := t.readFile(f)
+		if err != nil {
+			log.Printf("tailsource: add events from %s: %s", f.path, err)
+			continue
+		}
+		if more {
+			busy = true
+		} else if !f.seen {
+			// rotated away or deleted, and now fully read
+			t.dropFile(f)
+		}
+	}
+
+	if t.dirty {
+		if err := t.savePositions(); err != nil {
+			log.Printf("tailsource: saving positions: %s", err)
+		}
+	}
+	return busy
+}
+
+// scan expands the glob patterns, opening any files we aren't following yet
+// and noticing files that were truncated in place.
+func (t *TailSource) scan() {
+	for _, f := range t.files {
+		f.seen = false
+	}
+
+	for _, pattern := range t.patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			key, ok := tailKey(info)
+			if !ok {
+				continue
+			}
+
+			if f, ok := t.files[key]; ok {
+				f.seen = true
+				f.path = path
+				if info.Size() < f.readOffset {
+					log.Printf("tailsource: %s was truncated, reading from start", path)
+					t.rewind(f, 0)
+				}
+				continue
+			}
+
+			f, err := t.openFile(path, key, info.Size())
+			if err != nil {
+				log.Printf("tailsource: opening %s: %s", path, err)
+				continue
+			}
+			f.seen = true
+			t.files[key] = f
+		}
+	}
+}
+
+func (t *TailSource) openFile(path string, key tailFileKey, size int64) (*tailedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &tailedFile{key: key, path: path, file: file, lastData: time.Now()}
+	offset := int64(0)
+	if pos, ok := t.positions[key]; ok && pos.Offset <= size {
+		offset = pos.Offset
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.reader = bufio.NewReader(file)
+	f.readOffset = offset
+	f.committed = offset
+
+	log.Printf("tailsource: following %s from offset %d", path, offset)
+	return f, nil
+}
+
+func (t *TailSource) dropFile(f *tailedFile) {
+	f.file.Close()
+	delete(t.files, f.key)
+	delete(t.positions, f.key)
+	t.dirty = true
+	log.Printf("tailsource: finished %s", f.path)
+}
+
+// orderedFiles puts files that have been rotated away first so that their
+// remaining lines are sent before those of the file that replaced them.
+func (t *TailSource) orderedFiles() []*tailedFile {
+	files := make([]*tailedFile, 0, len(t.files))
+	for _, f := range t.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].seen != files[j].seen {
+			return !files[i].seen
+		}
+		return files[i].path < files[j].path
+	})
+	return files
+}
+
+// readFile sends any new records from f to the channels, returning true if
+// it stopped early because there is more data waiting.
+func (t *TailSource) readFile(f *tailedFile) (bool, error) {
+	records, more := t.readRecords(f, !f.seen)
+	if len(records) == 0 {
+		return more, nil
+	}
+
+	events := make([]Event, len(records))
+	for i, record := range records {
+		events[i] = record.event
+	}
+	for _, channel := range t.channels {
+		if err := channel.AddEvents(events); err != nil {
+			t.rewind(f, f.committed)
+			return false, err
+		}
+	}
+
+	f.committed = records[len(records)-1].end
+	t.positions[f.key] = tailPosition{
+		Path:   f.path,
+		Device: f.key.Device,
+		Inode:  f.key.Inode,
+		Offset: f.committed,
+	}
+	t.dirty = true
+	return more, nil
+}
+
+// readRecords reads up to TAIL_MAX_BATCH records from f.  A pending
+// multi-line record is only finished once the next record starts, or once
+// the file has been quiet for multilineTimeout.  When final is set the file
+// won't grow any more, so any unterminated line or record is sent as well.
+func (t *TailSource) readRecords(f *tailedFile, final bool) ([]tailRecord, bool) {
+	records := make([]tailRecord, 0)
+	for len(records) < TAIL_MAX_BATCH {
+		chunk, err := f.reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			f.lastData = time.Now()
+		}
+		lineStart := f.readOffset - int64(len(f.partial))
+		f.readOffset += int64(len(chunk))
+
+		if err == bufio.ErrBufferFull || (err == io.EOF && len(chunk) > 0) {
+			f.partial = append(f.partial, chunk...)
+			if len(f.partial) < t.maxLineLength {
+				if err == io.EOF {
+					break
+				}
+				continue
+			}
+			// overlong lines are split rather than held indefinitely
+			chunk, f.partial = f.partial, nil
+		} else if err != nil {
+			if err != io.EOF {
+				log.Printf("tailsource: reading %s: %s", f.path, err)
+			}
+			break
+		} else if len(f.partial) > 0 {
+			chunk = append(f.partial, chunk...)
+			f.partial = nil
+		}
+
+		records = t.addLine(f, records, chunk, lineStart)
+	}
+
+	more := len(records) >= TAIL_MAX_BATCH
+	if more {
+		return records, true
+	}
+	if final && len(f.partial) > 0 {
+		chunk := f.partial
+		f.partial = nil
+		records = t.addLine(f, records, chunk, f.readOffset-int64(len(chunk)))
+	}
+	if f.hasRecord && (final || time.Since(f.lastData) >= t.multilineTimeout) {
+		records = append(records, t.newRecord(f, f.record, f.readOffset-int64(len(f.partial))))
+		f.hasRecord = false
+	}
+	return records, false
+}
+
+// addLine handles a line read from f which started at lineStart, either
+// adding it to records directly or to the multi-line record being built.
+func (t *TailSource) addLine(f *tailedFile, records []tailRecord, chunk []byte, lineStart int64) []tailRecord {
+	line := bytes.TrimRight(chunk, "\r\n")
+	if t.multilineStart == nil {
+		return append(records, t.newRecord(f, line, lineStart+int64(len(chunk))))
+	}
+
+	if f.hasRecord && t.multilineStart.Match(line) {
+		records = append(records, t.newRecord(f, f.record, lineStart))
+		f.hasRecord = false
+	}
+	if !f.hasRecord {
+		f.record = append([]byte(nil), line...)
+		f.hasRecord = true
+	} else {
+		f.record = append(append(f.record, '\n'), line...)
+	}
+	return records
+}
+
+func (t *TailSource) newRecord(f *tailedFile, body []byte, end int64) tailRecord {
+	e := NewEvent()
+	e.Body = append([]byte(nil), body...)
+	SetEventTime(&e, time.Now(), t.timestampFormat)
+	e.Headers["File"] = f.path
+	e.Headers["Host"] = t.host
+	return tailRecord{event: e, end: end}
+}
+
+// rewind discards anything read past offset so it will be read again
+func (t *TailSource) rewind(f *tailedFile, offset int64) {
+	if _, err := f.file.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("tailsource: seeking %s: %s", f.path, err)
+		return
+	}
+	f.reader.Reset(f.file)
+	f.readOffset = offset
+	f.committed = offset
+	f.partial = nil
+	f.record = nil
+	f.hasRecord = false
+}
+
+func (t *TailSource) loadPositions() error {
+	raw, err := ioutil.ReadFile(t.positionsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var positions []tailPosition
+	if err := json.Unmarshal(raw, &positions); err != nil {
+		return err
+	}
+	for _, pos := range positions {
+		t.positions[tailFileKey{pos.Device, pos.Inode}] = pos
+	}
+	return nil
+}
+
+// savePositions writes to a temporary file and renames it into place so a
+// crash never leaves a half written positions file behind.
+func (t *TailSource) savePositions() error {
+	positions := make([]tailPosition, 0, len(t.positions))
+	for _, pos := range t.positions {
+		positions = append(positions, pos)
+	}
+	raw, err := json.Marshal(positions)
+	if err != nil {
+		return err
+	}
+
+	tmp := t.positionsPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.positionsPath); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+func tailKey(info os.FileInfo) (tailFileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return tailFileKey{}, false
+	}
+	return tailFileKey{Device: uint64(stat.Dev), Inode: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func initTailSourceTest(t *testing.T, extra ComponentSettings) (string, *TailSource, Channel) {
+	dir, err := ioutil.TempDir("", "collectord_tail")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	c := ComponentSettings{
+		"paths":     path.Join(dir, "*.log"),
+		"positions": path.Join(dir, "positions.json"),
+	}
+	for k, v := range extra {
+		c[k] = v
+	}
+	source := NewTailSource(c).(*TailSource)
+	channel := NewMemoryChannel(ComponentSettings{})
+	source.SetChannel(channel)
+	return dir, source, channel
+}
+
+func appendFile(t *testing.T, name string, data string) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %s", name, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatalf("Failed to write %s: %s", name, err)
+	}
+}
+
+func expectBodies(t *testing.T, c Channel, expected ...string) {
+	count, events, err := c.GetAll()
+	if err != nil {
+		t.Fatalf("Failed to get events: %s", err)
+	}
+	c.ConfirmGet(count)
+	if count != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %v", len(expected), count, events)
+	}
+	for i, body := range expected {
+		if string(events[i].Body) != body {
+			t.Errorf("Event %d: expected body %q, got %q", i, body, events[i].Body)
+		}
+	}
+}
+
+// The tests drive poll themselves rather than Start, whose goroutine would
+// race with them and outlive the temp dir.
+
+func TestTailSourceRotation(t *testing.T) {
+	dir, source, channel := initTailSourceTest(t, nil)
+	defer os.RemoveAll(dir)
+
+	logFile := path.Join(dir, "app.log")
+	appendFile(t, logFile, "one\ntwo\npartial")
+	source.forgetGone()
+	source.poll()
+	expectBodies(t, channel, "one", "two")
+
+	// rename rotation, the old file gets the rest of its last line
+	rotated := path.Join(dir, "app.log.1")
+	if err := os.Rename(logFile, rotated); err != nil {
+		t.Fatalf("Failed to rotate: %s", err)
+	}
+	appendFile(t, rotated, " line\n")
+	appendFile(t, logFile, "three\n")
+	source.poll()
+	expectBodies(t, channel, "partial line", "three")
+
+	// copytruncate rotation
+	if err := os.Truncate(logFile, 0); err != nil {
+		t.Fatalf("Failed to truncate: %s", err)
+	}
+	appendFile(t, logFile, "4\n")
+	source.poll()
+	expectBodies(t, channel, "4")
+}
+
+func TestTailSourceResume(t *testing.T) {
+	dir, source, channel := initTailSourceTest(t, nil)
+	defer os.RemoveAll(dir)
+
+	logFile := path.Join(dir, "app.log")
+	appendFile(t, logFile, "one\ntwo\n")
+	source.forgetGone()
+	source.poll()
+	expectBodies(t, channel, "one", "two")
+
+	appendFile(t, logFile, "three\n")
+	restarted := NewTailSource(ComponentSettings{
+		"paths":     path.Join(dir, "*.log"),
+		"positions": path.Join(dir, "positions.json"),
+	}).(*TailSource)
+	restarted.SetChannel(channel)
+	restarted.forgetGone()
+	restarted.poll()
+	expectBodies(t, channel, "three")
+}
+
+func TestTailSourceMultiline(t *testing.T) {
+	dir, source, channel := initTailSourceTest(t, ComponentSettings{
+		"multiline_start":   `^\d{4}-`,
+		"multiline_timeout": "1h",
+	})
+	defer os.RemoveAll(dir)
+
+	logFile := path.Join(dir, "app.log")
+	appendFile(t, logFile, "2026-10-18 panic\n  at foo\n  at bar\n2026-10-18 next\n")
+	source.forgetGone()
+	source.poll()
+	expectBodies(t, channel, "2026-10-18 panic\n  at foo\n  at bar")
+
+	appendFile(t, logFile, "2026-10-18 last\n")
+	source.poll()
+	expectBodies(t, channel, "2026-10-18 next")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every event carries its time in the Timestamp header.  Sources set it when
+// creating events, using the component's timestamp_format (unix_ms unless
+// configured otherwise), and sinks parse it back with EventTime.  Only the
+// named formats are allowed there, as those are the ones ParseTimestamp
+// understands without being told the layout.  Serializers may also use a Go
+// time layout, since what they write is never parsed back.
+
+var ErrBadTimestamp = errors.New("unrecognized timestamp")
+
+// Names for the timestamp formats understood by FormatTimestamp.  Anything
+// else is used as a Go time layout.
+const (
+	TIMESTAMP_UNIX         = "unix"
+	TIMESTAMP_UNIX_MS      = "unix_ms"
+	TIMESTAMP_UNIX_US      = "unix_us"
+	TIMESTAMP_UNIX_NS      = "unix_ns"
+	TIMESTAMP_RFC3339      = "rfc3339"
+	TIMESTAMP_RFC3339_MS   = "rfc3339_ms"
+	TIMESTAMP_RFC3339_NANO = "rfc3339_nano"
+)
+
+// timestampFormatSetting reads a component's timestamp_format setting
+func timestampFormatSetting(config ComponentSettings, def string) string {
+	if format, ok := config["timestamp_format"]; ok && format != "" {
+		return format
+	}
+	return def
+}
+
+// stampFormatSetting reads the timestamp_format a component stamps events
+// with, which must be one of the named formats
+func stampFormatSetting(component string, config ComponentSettings, def string) string {
+	format := timestampFormatSetting(config, def)
+	if !isNamedTimestampFormat(format) {
+		log.Fatalf("%s: timestamp_format %s isn't a named format", component, format)
+	}
+	return format
+}
+
+func isNamedTimestampFormat(format string) bool {
+	switch format {
+	case TIMESTAMP_UNIX, TIMESTAMP_UNIX_MS, TIMESTAMP_UNIX_US, TIMESTAMP_UNIX_NS,
+		TIMESTAMP_RFC3339, TIMESTAMP_RFC3339_MS, TIMESTAMP_RFC3339_NANO:
+		return true
+	}
+	return false
+}
+
+func FormatTimestamp(t time.Time, format string) string {
+	t = t.UTC()
+	switch format {
+	case TIMESTAMP_UNIX:
+		return strconv.FormatInt(t.Unix(), 10)
+	case TIMESTAMP_UNIX_MS:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	case TIMESTAMP_UNIX_US:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 10)
+	case TIMESTAMP_UNIX_NS:
+		return strconv.FormatInt(t.UnixNano(), 10)
+	case TIMESTAMP_RFC3339:
+		return t.Format(time.RFC3339)
+	case TIMESTAMP_RFC3339_MS:
+		return t.Format("2006-01-02T15:04:05.000Z07:00")
+	case TIMESTAMP_RFC3339_NANO:
+		return t.Format(time.RFC3339Nano)
+	}
+	return t.Format(format)
+}
+
+// ParseTimestamp understands every named format.  The precision of unix
+// timestamps is inferred from the number of digits, so seconds, millis,
+// micros and nanos all parse (seconds may also have a decimal fraction).
+func ParseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, ErrBadTimestamp
+	}
+
+	if isDigits([]byte(value)) {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, ErrBadTimestamp
+		}
+		switch {
+		case len(value) <= 10:
+			return time.Unix(n, 0).UTC(), nil
+		case len(value) <= 13:
+			return time.Unix(0, n*int64(time.Millisecond)).UTC(), nil
+		case len(value) <= 16:
+			return time.Unix(0, n*int64(time.Microsecond)).UTC(), nil
+		}
+		return time.Unix(0, n).UTC(), nil
+	}
+
+	if dot := strings.IndexByte(value, '.'); dot > 0 && isDigits([]byte(value[:dot])) && isDigits([]byte(value[dot+1:])) {
+		f, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			sec := int64(f)
+			return time.Unix(sec, int64((f-float64(sec))*float64(time.Second))).UTC(), nil
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, ErrBadTimestamp
+}
+
+// ParseTimestampLayout parses value with layout, which may be one of the
+// named formats or a Go time layout.  An empty layout guesses the format.
+func ParseTimestampLayout(value string, layout string) (time.Time, error) {
+	if layout == "" || isNamedTimestampFormat(layout) {
+		return ParseTimestamp(value)
+	}
+	t, err := time.Parse(layout, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, ErrBadTimestamp
+	}
+	return t.UTC(), nil
+}
+
+// EventTime returns the time from an event's Timestamp header
+func EventTime(e Event) (time.Time, bool) {
+	t, err := ParseTimestamp(e.Headers["Timestamp"])
+	return t, err == nil
+}
+
+func SetEventTime(e *Event, t time.Time, format string) {
+	e.Headers["Timestamp"] = FormatTimestamp(t, format)
+}
+
+// stampEvent normalizes an event's Timestamp header to format, setting it to
+// now if the event doesn't have a valid one yet.
+func stampEvent(e *Event, now time.Time, format string) {
+	t, ok := EventTime(*e)
+	if !ok {
+		t = now
+	}
+	SetEventTime(e, t, format)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestampPrecision(t *testing.T) {
+	expected := time.Date(2014, time.October, 22, 17, 46, 40, 123456789, time.UTC)
+	for value, want := range map[string]time.Time{
+		"1414000000":                          expected.Truncate(time.Second),
+		"1414000000.5":                        expected.Truncate(time.Second).Add(500 * time.Millisecond),
+		"1414000000123":                       expected.Truncate(time.Millisecond),
+		"1414000000123456":                    expected.Truncate(time.Microsecond),
+		"1414000000123456789":                 expected,
+		"2014-10-22T17:46:40.123Z":            expected.Truncate(time.Millisecond),
+		"2014-10-22T19:46:40.123456789+02:00": expected,
+	} {
+		got, err := ParseTimestamp(value)
+		if err != nil {
+			t.Errorf("%s: %s", value, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("%s: expected %s, got %s", value, want, got)
+		}
+	}
+
+	if _, err := ParseTimestamp("yesterday"); err != ErrBadTimestamp {
+		t.Errorf("Expected ErrBadTimestamp, got %v", err)
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	ts := time.Date(2014, time.October, 22, 17, 46, 40, 123456789, time.UTC)
+	for format, want := range map[string]string{
+		TIMESTAMP_UNIX:       "1414000000",
+		TIMESTAMP_UNIX_MS:    "1414000000123",
+		TIMESTAMP_RFC3339_MS: "2014-10-22T17:46:40.123Z",
+		"2006/01/02":         "2014/10/22",
+	} {
+		if got := FormatTimestamp(ts, format); got != want {
+			t.Errorf("%s: expected %s, got %s", format, want, got)
+		}
+	}
+}
+
+func TestTimestampInterceptor(t *testing.T) {
+	interceptor := NewTimestampInterceptor(ComponentSettings{
+		"field":            "meta.time",
+		"timestamp_format": TIMESTAMP_RFC3339_MS,
+	})
+
+	e := NewEvent()
+	e.Headers["Timestamp"] = "1414000000"
+	e.Body = []byte(`{"meta": {"time": "2026-10-18T14:00:00.250Z"}}`)
+	shared := e.Headers
+
+	e, keep := interceptor.Intercept(e)
+	if !keep {
+		t.Fatalf("Event dropped")
+	}
+	if e.Headers["Timestamp"] != "2026-10-18T14:00:00.250Z" {
+		t.Errorf("Timestamp not taken from body: %s", e.Headers["Timestamp"])
+	}
+	if shared["Timestamp"] != "1414000000" {
+		t.Errorf("Interceptor modified a shared headers map")
+	}
+
+	e.Body = []byte(`not json`)
+	e, _ = interceptor.Intercept(e)
+	if e.Headers["Timestamp"] != "2026-10-18T14:00:00.250Z" {
+		t.Errorf("Existing timestamp not kept: %s", e.Headers["Timestamp"])
+	}
+}
+
+func TestNamedFormatsParse(t *testing.T) {
+	ts := time.Date(2014, time.October, 22, 17, 46, 40, 123000000, time.UTC)
+	for _, format := range []string{TIMESTAMP_UNIX_MS, TIMESTAMP_UNIX_US, TIMESTAMP_UNIX_NS,
+		TIMESTAMP_RFC3339_MS, TIMESTAMP_RFC3339_NANO} {
+		if !isNamedTimestampFormat(format) {
+			t.Errorf("%s: expected a named format", format)
+		}
+		if got, err := ParseTimestamp(FormatTimestamp(ts, format)); err != nil || !got.Equal(ts) {
+			t.Errorf("%s: expected %s back, got %s %v", format, ts, got, err)
+		}
+	}
+	if isNamedTimestampFormat("2006/01/02") {
+		t.Error("Expected a Go layout not to be a named format")
+	}
+}
@@ -1,12 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"time"
 )
 
+// ErrChannelFull is returned by channels that are at capacity and can't
+// accept more events until sinks catch up.  Sources should treat it as
+// backpressure and have their clients retry later.
+var ErrChannelFull = errors.New("channel full")
+
+// Event is the unit of data passed from sources through channels to sinks.
+// ID is assigned once when the event is created and stays the same through
+// retries and hops between collectors.  TypedHeaders holds the typed values
+// of headers set with SetHeader; every header is in Headers as a string.
 type Event struct {
-	Headers map[string]string
-	Body    []byte
+	Version      int
+	ID           string
+	IngestTime   time.Time
+	Headers      map[string]string
+	TypedHeaders map[string]Value `json:",omitempty"`
+	Body         []byte
 }
 
 type Channel interface {
@@ -18,12 +34,24 @@ type Channel interface {
 	GetOldest(int) (int, []Event, error)
 	GetAll() (int, []Event, error)
 	ConfirmGet(int) error
+	// WaitForEvents blocks until the channel has events, the timeout elapses
+	// or ctx is done, returning whether events are available.  A timeout <= 0
+	// waits until events arrive or ctx is done.
+	WaitForEvents(ctx context.Context, timeout time.Duration) bool
 
 	Start() error
 
 	ReloadConfig(config ComponentSettings) bool
 }
 
+// ConsumerChannel is implemented by channels that can keep a separate read
+// position for each sink bound to them.  Sinks are bound to the channel
+// returned by Consumer, named after the sink (or its consumer setting).
+type ConsumerChannel interface {
+	Channel
+	Consumer(name string) (Channel, error)
+}
+
 type Sink interface {
 	SetChannel(Channel) error
 	Start() error
@@ -31,6 +59,12 @@ type Sink interface {
 	ReloadConfig(config ComponentSettings) bool
 }
 
+// Stopper is implemented by sources and sinks with work to finish before the
+// collector exits, such as files to close.  Sources are stopped first.
+type Stopper interface {
+	Stop() error
+}
+
 type Source interface {
 	SetChannel(Channel) error
 	Start() error
@@ -39,7 +73,13 @@ type Source interface {
 }
 
 func NewEvent() Event {
-	return Event{make(map[string]string), make([]byte, 0)}
+	return Event{
+		Version:    EVENT_VERSION,
+		ID:         newEventID(),
+		IngestTime: time.Now().UTC(),
+		Headers:    make(map[string]string),
+		Body:       make([]byte, 0),
+	}
 }
 
 // Global source registry
@@ -1,5 +1,9 @@
 package main
 
+import (
+	"strings"
+)
+
 func IntMax(a, b int) int {
 	if a >= b {
 		return a
@@ -13,3 +17,14 @@ func IntMin(a, b int) int {
 	}
 	return b
 }
+
+// splitList splits a comma separated setting, dropping empty entries
+func splitList(setting string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(setting, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}